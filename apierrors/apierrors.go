@@ -0,0 +1,181 @@
+// Package apierrors traduce los errores de la aplicación a respuestas HTTP con el formato
+// de models.ErrorResponse. Lo usan tanto los handlers de controllers como los middlewares,
+// así un mismo error responde siempre con el mismo código HTTP y el mismo código estable.
+// No importa database (que se conecta a mongo al iniciarse): sus errores los registra
+// controllers con Register.
+package apierrors
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/FrancoRutigliano/EcommerceGolang/tokens"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// Error es un error que ya sabe cómo responderse: código HTTP, código estable
+// (el que usa el cliente para decidir qué hacer) y mensaje.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New crea un Error para responder con Respond.
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Internal es un 500 con un mensaje que explica qué operación falló.
+// El error original ya tiene que estar registrado en el log.
+func Internal(message string) *Error {
+	return New(http.StatusInternalServerError, "internal_error", message)
+}
+
+// Errores que responden tanto los middlewares como los handlers
+var (
+	ErrUnauthenticated = New(http.StatusUnauthorized, "unauthenticated", "user is not authenticated")
+	ErrTokenRevoked    = New(http.StatusUnauthorized, "token_revoked", "token has been revoked")
+	ErrAdminRequired   = New(http.StatusForbidden, "admin_required", "admin role required")
+	ErrInternal        = New(http.StatusInternalServerError, "internal_error", "internal server error")
+)
+
+// mapping asocia un error sentinela de otro paquete con su respuesta.
+type mapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// mappings es el único lugar donde los errores sentinela de otros paquetes se traducen
+// a HTTP: 400 si la solicitud es incorrecta, 401 si el token no sirve, 404 si falta lo pedido,
+// 409 si choca con el estado actual y 500 para las fallas propias. Se comparan con errors.Is en orden.
+var mappings = []mapping{
+	{models.ErrCurrencyMismatch, http.StatusConflict, "currency_mismatch"},
+
+	{tokens.ErrTokenExpired, http.StatusUnauthorized, "token_expired"},
+	{tokens.ErrTokenMalformed, http.StatusUnauthorized, "token_malformed"},
+	{tokens.ErrTokenSignatureInvalid, http.StatusUnauthorized, "token_signature_invalid"},
+	{tokens.ErrTokenInvalid, http.StatusUnauthorized, "token_invalid"},
+	{tokens.ErrRefreshTokenReused, http.StatusUnauthorized, "refresh_token_reused"},
+	{tokens.ErrSessionEnded, http.StatusUnauthorized, "session_logged_out"},
+}
+
+// Register agrega la respuesta de un error sentinela de un paquete que apierrors no importa.
+// Se llama solo desde un init, antes de atender solicitudes.
+func Register(err error, status int, code string) {
+	mappings = append(mappings, mapping{err, status, code})
+}
+
+// detailer lo implementan los errores que llevan datos extra para el cliente
+// (models.ErrorResponse.Details), por ejemplo los productos sin stock.
+type detailer interface {
+	ErrorDetails() interface{}
+}
+
+// Respond es la única forma de responder un error, desde un handler o un middleware. Corta la
+// cadena de handlers y responde con models.ErrorResponse; quien la llama solo tiene que hacer return.
+// Un error desconocido se registra en el log y al cliente le llega un 500 genérico.
+func Respond(c *gin.Context, err error) {
+	if c.Writer.Written() {
+		// Ya se mandó una respuesta: nunca escribimos dos cuerpos en la misma solicitud
+		log.Printf("error after the response was written: %v", err)
+		c.Abort()
+		return
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		c.AbortWithStatusJSON(apiErr.Status, models.ErrorResponse{Code: apiErr.Code, Message: apiErr.Message})
+		return
+	}
+
+	if fields := FieldErrors(err); fields != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, models.ErrorResponse{Code: "validation_failed", Message: "some fields are not valid", Fields: fields})
+		return
+	}
+
+	for _, mapping := range mappings {
+		if errors.Is(err, mapping.err) {
+			// En los 4xx el mensaje puede traer detalle (ej: "cannot move order from shipped to pending");
+			// en los 500 solo mandamos el del sentinela, el resto queda en el log
+			response := models.ErrorResponse{Code: mapping.code, Message: err.Error()}
+			if mapping.status == http.StatusInternalServerError {
+				log.Println(err)
+				response.Message = mapping.err.Error()
+			} else if d := (detailer)(nil); errors.As(err, &d) {
+				response.Details = d.ErrorDetails()
+			}
+			c.AbortWithStatusJSON(mapping.status, response)
+			return
+		}
+	}
+
+	log.Println(err)
+	c.AbortWithStatusJSON(ErrInternal.Status, models.ErrorResponse{Code: ErrInternal.Code, Message: ErrInternal.Message})
+}
+
+// FieldErrors traduce los errores del validador a un FieldError por campo, con el nombre
+// json del campo (por ejemplo "pin_code" o "price.currency"), para mostrar junto a cada input.
+// Si err no es un error de validación devuelve nil.
+func FieldErrors(err error) []models.FieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+	fields := make([]models.FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		// El namespace empieza con el nombre del struct validado, que al cliente no le sirve
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		fields = append(fields, models.FieldError{
+			Field:   field,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldErrorMessage(fe),
+		})
+	}
+	return fields
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min", "gte":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max", "lte":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "max_bytes":
+		return fmt.Sprintf("must be at most %s bytes long", fe.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	case "url":
+		return "must be a valid URL"
+	case "iso4217":
+		return "must be an ISO 4217 currency code (e.g. USD)"
+	case "iso3166_1_alpha2":
+		return "must be a two-letter ISO 3166 country code (e.g. AR)"
+	case "postal_code":
+		return "is not a valid postal code for the selected country"
+	case "phone":
+		if fe.Param() != "" {
+			return "must be a phone number of the selected country in E.164 format (e.g. +5491112345678)"
+		}
+		return "must be a phone number in E.164 format (e.g. +14155552671)"
+	default:
+		return "is invalid"
+	}
+}
@@ -0,0 +1,88 @@
+package apierrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/FrancoRutigliano/EcommerceGolang/tokens"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errTestConflict = errors.New("test conflict")
+	errTestFailure  = errors.New("test failure")
+)
+
+// testDetailedError es un error con datos extra, como database.InsufficientStockError.
+type testDetailedError struct{ ids []string }
+
+func (e *testDetailedError) Error() string             { return "missing items" }
+func (e *testDetailedError) Is(target error) bool      { return target == errTestConflict }
+func (e *testDetailedError) ErrorDetails() interface{} { return map[string]interface{}{"ids": e.ids} }
+
+func init() {
+	Register(errTestConflict, http.StatusConflict, "test_conflict")
+	Register(errTestFailure, http.StatusInternalServerError, "test_failed")
+}
+
+func respond(err error) (int, models.ErrorResponse) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	Respond(c, err)
+
+	var body models.ErrorResponse
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return recorder.Code, body
+}
+
+func TestRespond(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+		wantDetails bool
+	}{
+		{"own error", ErrAdminRequired, http.StatusForbidden, "admin_required", "admin role required", false},
+		{"internal with message", Internal("could not verify token"), http.StatusInternalServerError, "internal_error", "could not verify token", false},
+		{"token sentinel", tokens.ErrTokenExpired, http.StatusUnauthorized, "token_expired", "token is expired", false},
+		{"models sentinel", models.ErrCurrencyMismatch, http.StatusConflict, "currency_mismatch", models.ErrCurrencyMismatch.Error(), false},
+		{"wrapped registered sentinel", fmt.Errorf("%w: order 42", errTestConflict),
+			http.StatusConflict, "test_conflict", "test conflict: order 42", false},
+		{"500 hides the detail", fmt.Errorf("%w: connection reset", errTestFailure),
+			http.StatusInternalServerError, "test_failed", "test failure", false},
+		{"error with details", fmt.Errorf("checkout: %w", &testDetailedError{ids: []string{"a"}}),
+			http.StatusConflict, "test_conflict", "checkout: missing items", true},
+		{"unknown error", errors.New("boom"), http.StatusInternalServerError, "internal_error", "internal server error", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := respond(tt.err)
+			if status != tt.wantStatus || body.Code != tt.wantCode {
+				t.Errorf("got %d %q, want %d %q", status, body.Code, tt.wantStatus, tt.wantCode)
+			}
+			if body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+			if (body.Details != nil) != tt.wantDetails {
+				t.Errorf("details = %v, want details: %v", body.Details, tt.wantDetails)
+			}
+		})
+	}
+}
+
+func TestFieldErrorsNotValidation(t *testing.T) {
+	if fields := FieldErrors(nil); fields != nil {
+		t.Errorf("FieldErrors(nil) = %v, want nil", fields)
+	}
+	if fields := FieldErrors(errors.New("boom")); fields != nil {
+		t.Errorf("FieldErrors(boom) = %v, want nil", fields)
+	}
+}
@@ -0,0 +1,123 @@
+// backfill-prices reescribe los precios guardados antes de Money, que eran un número entero
+// de unidades enteras sin moneda, como {amount, currency} en unidades menores.
+// Mientras no se corra esos precios se leen igual (ver models.LegacyMoney), pero la base no los
+// puede ordenar por price.amount y un número con decimales hace fallar la lectura del documento.
+// Es idempotente: solo toca documentos que todavía tienen algún precio numérico y el update
+// exige que el campo siga igual que cuando se leyó.
+//
+// Uso:
+//
+//	go run ./cmd/backfill-prices [-currency USD] [-dry-run]
+//
+// -currency es la moneda en la que estaban los precios viejos (por defecto models.DefaultCurrency).
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// priceField es un campo con precios: un Money suelto o un array de líneas con su price.
+type priceField struct {
+	collection *mongo.Collection
+	field      string
+	// numeric es el campo que queda numérico mientras falte migrar (field o field.price)
+	numeric string
+	// decoded devuelve dónde decodificar el campo; el decoder de models.Money hace la conversión
+	decoded func() interface{}
+}
+
+func main() {
+	currency := flag.String("currency", models.DefaultCurrency, "moneda ISO 4217 de los precios viejos")
+	dryRun := flag.Bool("dry-run", false, "solo informa lo que se actualizaría, sin escribir")
+	flag.Parse()
+	models.DefaultCurrency = strings.ToUpper(*currency)
+
+	productCollection := database.ProductData(database.Client, "Products")
+	userCollection := database.UserData(database.Client, "Users")
+	orderCollection := database.OrderData(database.Client, "Orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if err := database.Client.Ping(ctx, nil); err != nil {
+		log.Fatal("could not connect to MongoDB: ", err)
+	}
+
+	money := func() interface{} { return new(models.Money) }
+	lines := func() interface{} { return new([]models.ProductUser) }
+	fields := []priceField{
+		{productCollection, "price", "price", money},
+		{userCollection, "usercart", "usercart.price", lines},
+		{orderCollection, "order_list", "order_list.price", lines},
+		{orderCollection, "total_price", "total_price", money},
+		{orderCollection, "discount", "discount", money},
+	}
+
+	var failed int
+	for _, f := range fields {
+		found, updated, errors := backfill(ctx, f, *dryRun)
+		log.Printf("%s.%s: documents with legacy prices: %d, updated: %d, errors: %d", f.collection.Name(), f.field, found, updated, errors)
+		failed += errors
+	}
+
+	log.Printf("currency: %s, dry run: %t", models.DefaultCurrency, *dryRun)
+	if failed > 0 {
+		log.Fatal("backfill finished with errors, run it again to retry")
+	}
+}
+
+func backfill(ctx context.Context, f priceField, dryRun bool) (found, updated, failed int) {
+	filter := bson.D{primitive.E{Key: f.numeric, Value: bson.D{primitive.E{Key: "$type", Value: "number"}}}}
+	cursor, err := f.collection.Find(ctx, filter, options.Find().SetProjection(bson.D{primitive.E{Key: f.field, Value: 1}}))
+	if err != nil {
+		log.Println(err)
+		return 0, 0, 1
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		found++
+		id := cursor.Current.Lookup("_id")
+		raw := cursor.Current.Lookup(f.field)
+
+		value := f.decoded()
+		if err := raw.Unmarshal(value); err != nil {
+			log.Printf("%s %v: %v", f.collection.Name(), id, err)
+			failed++
+			continue
+		}
+		if dryRun {
+			continue
+		}
+
+		// Si el campo cambió desde que se leyó (por ejemplo el carrito) no se pisa: queda para otra corrida
+		result, err := f.collection.UpdateOne(ctx,
+			bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: f.field, Value: raw}},
+			bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: f.field, Value: value}}}},
+		)
+		if err != nil {
+			log.Printf("%s %v: %v", f.collection.Name(), id, err)
+			failed++
+			continue
+		}
+		if result.ModifiedCount > 0 {
+			updated++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println(err)
+		failed++
+	}
+	return found, updated, failed
+}
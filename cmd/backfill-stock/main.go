@@ -0,0 +1,107 @@
+// backfill-stock carga el campo stock en los productos creados antes de que existiera.
+// Sin ese campo la reserva de stock nunca encuentra el producto y toda compra responde 409,
+// así que hay que correrlo una vez después de desplegar el control de stock.
+// Es idempotente: solo toca productos sin stock (campo ausente o null) y deja un ajuste
+// en la auditoría de StockAdjustments por cada producto que actualiza.
+//
+// Uso:
+//
+//	go run ./cmd/backfill-stock [-stock 0] [-dry-run]
+//
+// Con -stock 0 (por defecto) los productos quedan sin unidades hasta que un admin las cargue
+// con POST /admin/products/:id/stock.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backfillAdmin es el admin_id con el que quedan los ajustes de la auditoría
+const backfillAdmin = "backfill-stock"
+
+func main() {
+	stock := flag.Int64("stock", 0, "unidades que se cargan en cada producto sin stock")
+	dryRun := flag.Bool("dry-run", false, "solo informa lo que se actualizaría, sin escribir")
+	flag.Parse()
+	if *stock < 0 {
+		log.Fatal("-stock cannot be negative")
+	}
+
+	productCollection := database.ProductData(database.Client, "Products")
+	adjustmentCollection := database.StockAdjustmentData(database.Client, "StockAdjustments")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if err := database.Client.Ping(ctx, nil); err != nil {
+		log.Fatal("could not connect to MongoDB: ", err)
+	}
+
+	// stock: null encuentra tanto el campo ausente como el guardado en null
+	missingStock := bson.D{primitive.E{Key: "stock", Value: nil}}
+	cursor, err := productCollection.Find(ctx, missingStock, options.Find().SetProjection(bson.D{primitive.E{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cursor.Close(ctx)
+
+	var products, updated, failed int
+	for cursor.Next(ctx) {
+		var product struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&product); err != nil {
+			log.Println(err)
+			failed++
+			continue
+		}
+		products++
+		if *dryRun {
+			continue
+		}
+
+		// El filtro repite la condición: si otro proceso ya le cargó stock no se pisa
+		filter := append(bson.D{primitive.E{Key: "_id", Value: product.ID}}, missingStock...)
+		result, err := productCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "stock", Value: *stock}}}})
+		if err != nil {
+			log.Printf("product %s: %v", product.ID.Hex(), err)
+			failed++
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		updated++
+
+		_, err = adjustmentCollection.InsertOne(ctx, models.StockAdjustment{
+			ID:          primitive.NewObjectID(),
+			Product_ID:  product.ID,
+			Delta:       *stock,
+			Stock_After: stock,
+			Reason:      "initial stock for a product created before stock tracking",
+			Admin_ID:    backfillAdmin,
+			Created_At:  time.Now(),
+		})
+		if err != nil {
+			// El stock ya quedó cargado; solo falta el registro de auditoría
+			log.Printf("product %s: could not record the adjustment: %v", product.ID.Hex(), err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("products without stock: %d, updated: %d, errors: %d (stock: %d, dry run: %t)", products, updated, failed, *stock, *dryRun)
+	if failed > 0 {
+		log.Fatal("backfill finished with errors, run it again to retry")
+	}
+}
@@ -0,0 +1,146 @@
+// migrate-orders mueve las órdenes guardadas dentro de cada usuario (array "orders")
+// a la colección Orders. Es idempotente: se puede correr varias veces sin duplicar órdenes.
+// Las órdenes viejas no tenían estado: se guardan como models.LegacyOrderStatus, con su entrada
+// en el historial, así nadie puede cancelarlas ni devolver al stock unidades que nunca se reservaron.
+// También completa el estado de las órdenes que una versión anterior de la migración dejó sin él.
+//
+// Uso:
+//
+//	go run ./cmd/migrate-orders [-dry-run]
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migratedBy es el autor de los cambios de estado que registra la migración
+const migratedBy = "migrate-orders"
+
+// legacyStatusChange es la entrada del historial de las órdenes migradas
+func legacyStatusChange() models.StatusChange {
+	return models.StatusChange{
+		Status: models.LegacyOrderStatus,
+		At:     time.Now(),
+		By:     migratedBy,
+		Reason: "order placed before order statuses existed",
+	}
+}
+
+// legacyUser es la parte del documento del usuario que necesita la migración
+type legacyUser struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	Orders []bson.M           `bson:"orders"`
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "solo informa lo que se migraría, sin escribir")
+	flag.Parse()
+
+	userCollection := database.UserData(database.Client, "Users")
+	orderCollection := database.OrderData(database.Client, "Orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if err := database.Client.Ping(ctx, nil); err != nil {
+		log.Fatal("could not connect to MongoDB: ", err)
+	}
+
+	// Solo los usuarios que todavía tienen el array viejo
+	filter := bson.D{primitive.E{Key: "orders", Value: bson.D{primitive.E{Key: "$exists", Value: true}}}}
+	cursor, err := userCollection.Find(ctx, filter, options.Find().SetProjection(bson.D{primitive.E{Key: "orders", Value: 1}}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cursor.Close(ctx)
+
+	var users, moved, failed int
+	for cursor.Next(ctx) {
+		var user legacyUser
+		if err := cursor.Decode(&user); err != nil {
+			log.Println(err)
+			failed++
+			continue
+		}
+		users++
+
+		ok := true
+		for _, order := range user.Orders {
+			orderID := order["_id"]
+			delete(order, "_id") // el _id sale del filtro del upsert
+			order["user_id"] = user.ID.Hex()
+			order["status"] = models.LegacyOrderStatus
+			order["status_history"] = []models.StatusChange{legacyStatusChange()}
+			if *dryRun {
+				moved++
+				continue
+			}
+			// $setOnInsert: si la orden ya se migró en una corrida anterior no se toca
+			_, err := orderCollection.UpdateOne(ctx,
+				bson.D{primitive.E{Key: "_id", Value: orderID}},
+				bson.D{{Key: "$setOnInsert", Value: order}},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil {
+				log.Printf("user %s, order %v: %v", user.ID.Hex(), orderID, err)
+				ok = false
+				continue
+			}
+			moved++
+		}
+
+		// El array viejo se borra solo si todas sus órdenes quedaron en Orders
+		if *dryRun || !ok {
+			if !ok {
+				failed++
+			}
+			continue
+		}
+		_, err := userCollection.UpdateOne(ctx,
+			bson.D{primitive.E{Key: "_id", Value: user.ID}},
+			bson.D{{Key: "$unset", Value: bson.D{primitive.E{Key: "orders", Value: ""}}}},
+		)
+		if err != nil {
+			log.Printf("user %s: %v", user.ID.Hex(), err)
+			failed++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Órdenes que ya están en Orders pero se migraron sin estado
+	withoutStatus := bson.D{primitive.E{Key: "status", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}}
+	var statusFixed int64
+	if *dryRun {
+		statusFixed, err = orderCollection.CountDocuments(ctx, withoutStatus)
+	} else {
+		var result *mongo.UpdateResult
+		result, err = orderCollection.UpdateMany(ctx, withoutStatus, bson.D{
+			{Key: "$set", Value: bson.D{primitive.E{Key: "status", Value: models.LegacyOrderStatus}}},
+			{Key: "$push", Value: bson.D{primitive.E{Key: "status_history", Value: legacyStatusChange()}}},
+		})
+		if result != nil {
+			statusFixed = result.ModifiedCount
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		failed++
+	}
+
+	log.Printf("users: %d, orders moved: %d, orders given a status: %d, errors: %d (dry run: %t)", users, moved, statusFixed, failed, *dryRun)
+	if failed > 0 {
+		log.Fatal("migration finished with errors, run it again to retry")
+	}
+}
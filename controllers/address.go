@@ -1 +1,195 @@
 package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListAddresses devuelve la libreta de direcciones del usuario autenticado.
+func (app *Application) ListAddresses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		addresses, err := database.Addresses(ctx, app.userCollection, userQueryID)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, addresses)
+	}
+}
+
+// AddAddress agrega una dirección a la libreta del usuario. House, Street, City y Pincode
+// son obligatorios y cada usuario puede guardar hasta database.MaxAddresses direcciones.
+// label puede ser home, work u other (por defecto), y con default_shipping o default_billing
+// en true la dirección pasa a ser la usada por defecto en el checkout.
+func (app *Application) AddAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		address, ok := bindAddress(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		address, err = database.AddAddress(ctx, app.userCollection, userQueryID, address)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, address)
+	}
+}
+
+// EditHomeAddress reemplaza los datos de la dirección con etiqueta home.
+func (app *Application) EditHomeAddress() gin.HandlerFunc {
+	return app.editAddressByLabel(models.ADDRESS_LABEL_HOME)
+}
+
+// EditWorkAddress reemplaza los datos de la dirección con etiqueta work.
+func (app *Application) EditWorkAddress() gin.HandlerFunc {
+	return app.editAddressByLabel(models.ADDRESS_LABEL_WORK)
+}
+
+// editAddressByLabel arma el handler que edita la dirección con la etiqueta indicada.
+// Las órdenes ya hechas guardan su propia copia de la dirección y no cambian.
+func (app *Application) editAddressByLabel(label models.AddressLabel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		address, ok := bindAddress(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		address, err = database.UpdateAddressByLabel(ctx, app.userCollection, userQueryID, label, address)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, address)
+	}
+}
+
+// DeleteAddress quita una dirección de la libreta (?id=<address_id>).
+func (app *Application) DeleteAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		addressID, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidAddressID)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteAddress(ctx, app.userCollection, userQueryID, addressID); err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully deleted the address"})
+	}
+}
+
+// DefaultAddressRequest es el cuerpo de SetDefaultAddress: qué tipo de dirección por defecto se elige.
+type DefaultAddressRequest struct {
+	Shipping bool `json:"shipping"`
+	Billing  bool `json:"billing"`
+}
+
+// SetDefaultAddress elige la dirección /addresses/:id como la de envío y/o facturación por defecto.
+func (app *Application) SetDefaultAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		addressID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidAddressID)
+			return
+		}
+
+		var body DefaultAddressRequest
+		if err := c.ShouldBindJSON(&body); err != nil || (!body.Shipping && !body.Billing) {
+			apierrors.Respond(c, errDefaultKindRequired)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var kinds []database.AddressDefault
+		if body.Shipping {
+			kinds = append(kinds, database.DefaultShipping)
+		}
+		if body.Billing {
+			kinds = append(kinds, database.DefaultBilling)
+		}
+		for _, kind := range kinds {
+			if err := database.SetDefaultAddress(ctx, app.userCollection, userQueryID, addressID, kind); err != nil {
+				apierrors.Respond(c, err)
+				return
+			}
+		}
+
+		addresses, err := database.Addresses(ctx, app.userCollection, userQueryID)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, addresses)
+	}
+}
+
+// bindAddress lee y valida la dirección del cuerpo de la solicitud.
+// Si algo falla ya respondió con 400 (con el error de cada campo) y devuelve false.
+func bindAddress(c *gin.Context) (models.Address, bool) {
+	var address models.Address
+	if err := c.ShouldBindJSON(&address); err != nil {
+		apierrors.Respond(c, bindError(err))
+		return address, false
+	}
+	normalizeAddress(&address)
+	if err := Validate.Struct(address); err != nil {
+		apierrors.Respond(c, err)
+		return address, false
+	}
+	return address, true
+}
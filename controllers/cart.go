@@ -7,8 +7,11 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
 	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/middleware"
 	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	generate "github.com/FrancoRutigliano/EcommerceGolang/tokens"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,19 +22,39 @@ import (
 // Para que recordemos en mongo, las colecciones son un conjunto de documentos que almacena
 // información de manera muy similar a las bases de datos relacionales.
 type Application struct {
-	prodCollection *mongo.Collection // Colección de productos
-	userCollection *mongo.Collection // Colección de usuarios
+	prodCollection  *mongo.Collection // Colección de productos
+	userCollection  *mongo.Collection // Colección de usuarios
+	orderCollection *mongo.Collection // Colección de órdenes
+	// Auditoría de los ajustes de stock que hacen los administradores
+	stockAdjustmentCollection *mongo.Collection
+	tokens                    *generate.Store // Tokens vigentes y lista de revocación
+	// Lo que consulta middleware.Authentication; salvo en los tests es el mismo Store de tokens
+	revocations middleware.RevocationChecker
 }
 
 // NewApplication es una función que actúa como constructor para la estructura Application.
 // Crea una nueva instancia de Application con las colecciones proporcionadas.
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
+func NewApplication(prodCollection, userCollection, orderCollection, stockAdjustmentCollection *mongo.Collection, tokens *generate.Store) *Application {
 	return &Application{
-		prodCollection: prodCollection, // Asigna la colección de productos proporcionada al campo prodCollection
-		userCollection: userCollection, // Asigna la colección de usuarios proporcionada al campo userCollection
+		prodCollection:            prodCollection,            // Asigna la colección de productos proporcionada al campo prodCollection
+		userCollection:            userCollection,            // Asigna la colección de usuarios proporcionada al campo userCollection
+		orderCollection:           orderCollection,           // Asigna la colección de órdenes proporcionada al campo orderCollection
+		stockAdjustmentCollection: stockAdjustmentCollection, // Asigna la colección de ajustes de stock al campo stockAdjustmentCollection
+		tokens:                    tokens,                    // Asigna el Store de tokens proporcionado al campo tokens
+		revocations:               tokens,                    // El Store también es la lista de revocación
 	}
 }
 
+// Revocations devuelve la lista de revocación con la que el router autentica las solicitudes.
+func (app *Application) Revocations() middleware.RevocationChecker {
+	return app.revocations
+}
+
+// SetRevocations reemplaza la lista de revocación; los tests la usan para no depender de mongo.
+func (app *Application) SetRevocations(revocations middleware.RevocationChecker) {
+	app.revocations = revocations
+}
+
 func (app *Application) AddToCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 'necesitamos checkear' si el id del producto esta en la base de datos, si existe
@@ -41,16 +64,15 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 		// Porque no podemos agregar un producto al carrito si no tenemos un id
 		if productQueryID == "" {
 			log.Println("product id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			apierrors.Respond(c, errInvalidProductID)
 			return
 		}
-		// También debemos verificar si el id de usuario existe
+		// También debemos saber qué usuario hace la solicitud
 		// Para: Integridad de los datos, Seguridad de control y acceso y para tener un registro de la actividad
-		userQueryID := c.Query("userID")
-		// Hacemos el check de si el usuario esta vacío, caso de que si, abortamos con error
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
 		}
 
 		// El id de producto fue recibido
@@ -61,7 +83,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 
 		if err != nil {
 			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierrors.Respond(c, errInvalidProductID)
 			return
 		}
 		// Ahora ya deberíamos poder llamar a la funcion que conceta con la DB en database
@@ -73,13 +95,12 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 
 		// si sucede algún error al momento de conectar a base de datos para agregar el producto
 		if err != nil {
-			// json identado, con un error de server
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			// respondemos con el código que corresponde al error de la base de datos
+			apierrors.Respond(c, err)
+			return
 		}
 		// status 200 se utiliza para saber que el proceso se terminó exitosamente
-		// IndentedJson devuelve una estructura Json 'más cuidada', pero cuidado, debería ser unicamente por motivos de desarrollo, porque consume más cpu y banda ancha
-		c.IndentedJSON(200, "Successfully Added to the cart")
-
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully Added to the cart"})
 	}
 }
 
@@ -89,15 +110,13 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		productQueryID := c.Query("id")
 		if productQueryID == "" {
 			log.Println("product id is invalid")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			apierrors.Respond(c, errInvalidProductID)
 			return
 		}
 
-		// Extraer el valor de el userID de una solicitud http
-		userQueryID := c.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
 			return
 		}
 
@@ -106,7 +125,8 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		ProductID, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
 			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierrors.Respond(c, errInvalidProductID)
+			return
 		}
 
 		// El contexto que vamos a declarar va a ser pasado a la funcion que hace conexion con la DB
@@ -116,30 +136,28 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, ProductID, userQueryID)
 		// Deberíamos comprobar si la conexion salió bien
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			apierrors.Respond(c, err)
+			return
 		}
 		// si todo salio bien
-		c.IndentedJSON(200, "Successfully removed from cart")
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully removed from cart"})
 	}
 }
 
-func GetItemFromCart() gin.HandlerFunc {
+func (app *Application) GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		// comprobamos si el id que devuelve el query esta vacio
-		if user_id == "" {
-			// al querer obtener un item del carrito vamos a devolver al header un content/type
-			// Encabezado de la solicitud http, con informacion extra de la solicitud en cuestion
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid id"})
-			// abortamos funcion
-			c.Abort()
+		user_id, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
 			return
 		}
 
 		// de lo que nos devuelve la base de datos probablemente en formato hexadecimal, lo tendremos que convertir para despues pasarlo a la funcion que llama a la base de datos
-
-		usert_id, _ := primitive.ObjectIDFromHex(user_id)
+		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		if err != nil {
+			apierrors.Respond(c, errInvalidUserID)
+			return
+		}
 
 		// vamos a crear un contexto que va a ser creado unicamente para la funcion que llame a la base de datos
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -148,45 +166,88 @@ func GetItemFromCart() gin.HandlerFunc {
 		var filledCart models.User
 
 		// BSON.D es una representacion ordenada de un BSON
-		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&filledCart)
+		err = app.userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&filledCart)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			apierrors.Respond(c, database.ErrUserIdIsNotValid)
+			return
+		}
 		if err != nil {
 			log.Println(err)
-			c.IndentedJSON(500, "id not found")
+			apierrors.Respond(c, apierrors.Internal("could not get the cart"))
 			return
 		}
 
-		filter_match := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: usert_id}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usertcart"}}}}
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "usertcart.price"}}}}}}
-		// Ahora vamos a ejecutar una operacion de agregacion a una colección
-		// Estas operaciones de agregación a la base de datos son filter_match, unwind y grouping
-		PointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filter_match, unwind, grouping})
-
+		// El total se calcula en Go para respetar la moneda de cada línea: precio * cantidad,
+		// y las líneas viejas sin cantidad cuentan como 1
+		total, err := models.CartTotal(filledCart.UserCart)
 		if err != nil {
-			log.Println(err)
+			apierrors.Respond(c, err)
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{"total": total, "usercart": filledCart.UserCart})
+	}
+}
 
-		var listing []bson.M
+// UpdateCartQuantity fija la cantidad de una línea del carrito (?id=<producto>, body {"quantity": N}).
+// Con cantidad 0 la línea se quita del carrito.
+func (app *Application) UpdateCartQuantity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productQueryID := c.Query("id")
+		if productQueryID == "" {
+			log.Println("product id is empty")
+			apierrors.Respond(c, errInvalidProductID)
+			return
+		}
+
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
 
-		if err = PointCursor.All(ctx, &listing); err != nil {
+		productID, err := primitive.ObjectIDFromHex(productQueryID)
+		if err != nil {
 			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierrors.Respond(c, errInvalidProductID)
 			return
 		}
-		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledCart.UserCart)
+
+		var body struct {
+			Quantity *int `json:"quantity" binding:"required"`
 		}
-		ctx.Done()
+		if err := c.ShouldBindJSON(&body); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
+		}
+		if *body.Quantity < 0 || *body.Quantity > database.MaxCartQuantity {
+			apierrors.Respond(c, database.ErrQuantityOutOfRange)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = database.SetCartItemQuantity(ctx, app.userCollection, productID, userQueryID, *body.Quantity)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully updated the cart"})
 	}
 }
 
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userQueryID := c.Query("id")
-		if userQueryID == "" {
-			log.Println("user ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		addressID, err := checkoutAddressID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
 		}
 
 		// Ahora debemos crear un context y una cancelacion del contexto. Todo esto para pasarselo a la funcion que llama a la base de datos
@@ -194,36 +255,42 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 		defer cancel()
 
 		// Vamos a llamar a la funcion que hace conexion con la base de datos
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryID)
+		err = database.BuyItemFromCart(ctx, app.prodCollection, app.userCollection, app.orderCollection, userQueryID, addressID)
 		// caso de que haya un problema en la conexion, damos un aviso del error
 		if err != nil {
-			log.Println(err)
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			apierrors.Respond(c, err)
+			return
 		}
-		c.IndentedJSON(200, "Successfully Placed the order")
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully Placed the order"})
 	}
 }
 
 func (app *Application) InstantBuy() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		UserQueryID := c.Query("userid")
-		// En el caso de que el user que devuelve el query este vacio
-		if UserQueryID == "" {
-			log.Println("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+		UserQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
 		}
 
 		ProductQueryID := c.Query("pid")
 		if ProductQueryID == "" {
 			log.Println("Product id is empty")
-			c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			apierrors.Respond(c, errInvalidProductID)
+			return
 		}
 		// Transformamos  de Hex a lo que devuelve mongo como parametro de la url http
 		productID, err := primitive.ObjectIDFromHex(ProductQueryID)
 		// si no se pudo hacer la conversion
 		if err != nil {
 			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierrors.Respond(c, errInvalidProductID)
+			return
+		}
+
+		addressID, err := checkoutAddressID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
 			return
 		}
 
@@ -231,13 +298,28 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 		defer cancel()
 
 		// Invocamos a la funcion que se va a conectar con la base de datos
-		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productID, UserQueryID)
+		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, app.orderCollection, productID, UserQueryID, addressID)
 		// debemos corroborar si el error no esta vacio
 		// ya que si esta vacio pudo haber algún problema en la conexion a base de datos
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			apierrors.Respond(c, err)
+			return
 		}
 
-		c.IndentedJSON(200, "Successfully placed the order")
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully placed the order"})
+	}
+}
+
+// checkoutAddressID lee la dirección de envío elegida para la compra (?address_id=).
+// Sin el parámetro devuelve primitive.NilObjectID y se usa la dirección por defecto.
+func checkoutAddressID(c *gin.Context) (primitive.ObjectID, error) {
+	value := c.Query("address_id")
+	if value == "" {
+		return primitive.NilObjectID, nil
+	}
+	addressID, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return primitive.NilObjectID, errInvalidAddressID
 	}
+	return addressID, nil
 }
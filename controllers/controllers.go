@@ -2,40 +2,77 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
 	"github.com/FrancoRutigliano/EcommerceGolang/database"
 	"github.com/FrancoRutigliano/EcommerceGolang/models"
 	generate "github.com/FrancoRutigliano/EcommerceGolang/tokens"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// Declaración e inicialización de la variable UserCollection que apunta a una colección de usuarios en MongoDB.
-var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
-
 // Declaración e inicialización de la variable ProductCollection que apunta a una colección de productos en MongoDB.
 var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
 
+// Colección de órdenes: cada orden es un documento propio con el user_id del comprador.
+var OrderCollection *mongo.Collection = database.OrderData(database.Client, "Orders")
+
 // Declaración e inicialización de la variable Validate como un validador nuevo esta variable Validate es
 // una instancia de un validador que se utilizará para validar datos en el código.
-var Validate = validator.New()
+// Incluye las reglas propias de códigos postales y teléfonos (ver newValidator).
+var Validate = newValidator()
+
+// PasswordCost es el costo de bcrypt con el que se generan los hashes nuevos.
+// Si lo subimos, los hashes viejos se regeneran solos en el próximo Login (ver PasswordNeedsRehash).
+var PasswordCost = bcrypt.DefaultCost
+
+// El hash guardado describe su propio algoritmo y parámetros: bcrypt genera cadenas del
+// estilo "$2a$10$<salt+hash>". Leyendo el prefijo sabemos cómo verificarlo, y el día que
+// agreguemos otro algoritmo (por ejemplo "$argon2id$...") conviven ambos formatos.
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
 
-func HashPassword(password string) string {
-	panic("Not Used Yet")
+func HashPassword(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
 }
 
+// VerifyPassword compara la contraseña en texto plano con el hash guardado en la base de datos.
 func VerifyPassword(userPassword string, givePassword string) (bool, string) {
-	panic("Not Used Yet")
+	if !isBcryptHash(givePassword) {
+		return false, "unsupported password hash format"
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(givePassword), []byte(userPassword)); err != nil {
+		return false, "login or password is incorrect"
+	}
+	return true, ""
+}
+
+// PasswordNeedsRehash indica si el hash usa un algoritmo o parámetros más débiles que los actuales.
+func PasswordNeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < PasswordCost
 }
 
-func Sigup() gin.HandlerFunc {
+func (app *Application) Signup() gin.HandlerFunc {
 	// Esta funcion maneja el registro de los usuarios
 	return func(c *gin.Context) {
 		// Se crea un contexto con un timeOut de 100 segundos
@@ -45,8 +82,8 @@ func Sigup() gin.HandlerFunc {
 		// Se crea una variable user del módelo 'User' para almacenar los datos del usuario
 		var user models.User
 		// Intentaremos extraer y parsear los datos del JSON del cuerpo de la solicitud al módelo user.
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"erorr": err.Error()})
+		if err := c.ShouldBindJSON(&user); err != nil {
+			apierrors.Respond(c, bindError(err))
 			return
 		}
 		// Se valida la estructura del usuario usando
@@ -56,42 +93,47 @@ func Sigup() gin.HandlerFunc {
 			Esto ayuda a asegurar la integridad y consistencia de los datos antes de continuar
 			con el proceso de registro del usuario.
 		*/
+		normalizePhone(user.Phone)
 		validationErr := Validate.Struct(user)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
+			apierrors.Respond(c, validationErr)
 			return
 		}
 		// Se verifica si el correo electronico ya esta en la base de datos
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		count, err := app.userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not check the email"))
+			return
 		}
 
 		if count > 0 {
-			// Si el correo electronico ya existe, se devuelve un error
-			c.JSON(http.StatusBadRequest, gin.H{"error": "user email already exist"})
+			// Si el correo electronico ya existe, se devuelve un conflicto
+			apierrors.Respond(c, errEmailTaken)
+			return
 		}
 
 		// Vereficamos si el numero de telefono del usuario ya existe en la base de datos.
-		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
-
-		defer cancel()
-
+		count, err = app.userCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not check the phone"))
 			return
 		}
 
 		if count > 0 {
-			// Si el numero de telefono ya esta en uso se devuelve un error.
-			c.JSON(http.StatusBadRequest, gin.H{"error": "this phone no. is already in use"})
+			// Si el numero de telefono ya esta en uso se devuelve un conflicto.
+			apierrors.Respond(c, errPhoneTaken)
 			return
 		}
 		// HashPassword convierte la contraseña en una
 		// cadena irreversible para protegerla en la base de datos.
-		password := HashPassword(*user.Password)
+		password, err := HashPassword(*user.Password)
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not hash password"))
+			return
+		}
 		// En vez de guardar la contraseña en texto(String), la guardamos en la base de datos hasheada
 		user.Password = &password
 
@@ -100,83 +142,273 @@ func Sigup() gin.HandlerFunc {
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
+		// El rol lo decide el servidor: todo registro público crea un usuario común
+		userType := models.USER_TYPE_USER
+		user.User_Type = &userType
 
 		// Se generan tokens de autenticación para el usuario
-		token, refreshtoken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
-		user.Token = &token
-		user.Refresh_Token = &refreshtoken
+		token, refreshtoken, err := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, *user.User_Type, "")
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not generate tokens"))
+			return
+		}
+		// El registro abre la primera sesión del usuario; los tokens no se guardan en el documento
+		session, err := generate.NewSession(refreshtoken)
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not generate tokens"))
+			return
+		}
+		user.Sessions = []models.Session{session}
 
-		// Se inicializan las listas asociadas al usuario (carrito, direcciones, estado de órdenes)
+		// Se inicializan las listas asociadas al usuario (carrito y direcciones)
 		user.UserCart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
-		user.Order_Status = make([]models.Order, 0)
 
 		// Se inserta el usuario en la base de datos
 		/*
-			Utilizamos UserCollection.InsertOne para guardar el objeto user en la base de datos.
+			Utilizamos app.userCollection.InsertOne para guardar el objeto user en la base de datos.
 			Si ocurre algún error durante el proceso de inserción, se envía un mensaje de
 			error al cliente indicando que la creación del usuario no se completó correctamente.
 		*/
-		_, inserterr := UserCollection.InsertOne(ctx, user)
+		_, inserterr := app.userCollection.InsertOne(ctx, user)
+		if mongo.IsDuplicateKeyError(inserterr) {
+			apierrors.Respond(c, errUserExists)
+			return
+		}
 		if inserterr != nil {
 			// Si hay un error al insertar el usuario, se devuelve un error
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "the user did not get created"})
+			log.Println(inserterr)
+			apierrors.Respond(c, apierrors.Internal("the user did not get created"))
 			return
 		}
-		defer cancel()
-
-		// Si todo salió bien, se devuelve un mensaje de exito al cliente.
-		c.JSON(http.StatusCreated, "Successfully Signed In")
 
+		// Si todo salió bien, devolvemos el usuario (sin la contraseña) y sus tokens.
+		c.JSON(http.StatusCreated, models.AuthResponse{
+			User:          models.NewUserResponse(user),
+			Token:         token,
+			Refresh_Token: refreshtoken,
+		})
 	}
 }
 
-func Login() gin.HandlerFunc {
+// LoginRequest son las credenciales que recibe Login. No usamos models.User para que
+// el cliente no pueda mandar otros campos del usuario.
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (app *Application) Login() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		// Crear un contexto con un límite de tiempo de 100 segundos
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel() // Cancelar el contexto cuando la función retorne
 
-		var user models.User      // Crear una variable para almacenar un usuario
-		var founduser models.User // Crear una variable para almacenar un usuario encontrado
+		var credentials LoginRequest // Credenciales enviadas por el cliente
+		var founduser models.User    // Crear una variable para almacenar un usuario encontrado
 
-		// Intentar vincular el cuerpo de la solicitud JSON al objeto 'user'
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err}) // Enviar una respuesta de error si hay un problema con el JSON
+		// Intentar vincular el cuerpo de la solicitud JSON a las credenciales
+		if err := c.ShouldBindJSON(&credentials); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
 		}
 
-		// Buscar un usuario en la base de datos usando el email proporcionado en 'user'
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
-		defer cancel() // Asegurarse de cancelar el contexto al final de la función
-
+		// Buscar un usuario en la base de datos usando el email proporcionado
+		err := app.userCollection.FindOne(ctx, bson.M{"email": credentials.Email}).Decode(&founduser)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Mismo mensaje que con contraseña incorrecta, así no se puede averiguar qué emails existen
+			apierrors.Respond(c, errInvalidCredentials)
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
-			return // Enviar un mensaje de error si no se encuentra el usuario en la base de datos
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not find the user"))
+			return
 		}
 
-		// ... (Aquí faltaría agregar la lógica para comparar contraseñas o realizar alguna acción con el usuario encontrado)
-		PasswordIsValid, msg := VerifyPassword(*user.Password, *founduser.Password)
-
-		defer cancel()
-
 		// Todo esta lógica estaría sucediendo si la contraseña no es valida.
 		// Para determinar esto, tenemos que checkear la password de ese usuario que tenemos en la DB y las Password que el usuario nos entrega en el login
+		if founduser.Password == nil {
+			apierrors.Respond(c, errInvalidCredentials)
+			return
+		}
+		PasswordIsValid, msg := VerifyPassword(credentials.Password, *founduser.Password)
 		if !PasswordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			fmt.Println(msg)
+			log.Println(msg)
+			apierrors.Respond(c, errInvalidCredentials)
 			return
 		}
+		// La contraseña es correcta: si el hash quedó desactualizado lo regeneramos con los parámetros actuales
+		if PasswordNeedsRehash(*founduser.Password) {
+			rehashPassword(ctx, app.userCollection, founduser.User_ID, credentials.Password)
+		}
+
 		// Si estas dos contraseñas "machean", generamos el token
-		token, refreshToken, _ := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID)
+		token, refreshToken, err := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID, userTypeOf(founduser), "")
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not generate tokens"))
+			return
+		}
+		// luego de generar el token, abrimos una sesión nueva para este login.
+		// Las sesiones de otros dispositivos siguen vigentes
+		if err := app.tokens.StartSession(founduser.User_ID, refreshToken); err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not update tokens"))
+			return
+		}
+
+		// Caso de que todo funcione bien, devolvemos el usuario sin datos sensibles y sus tokens
+		c.JSON(http.StatusOK, models.AuthResponse{
+			User:          models.NewUserResponse(founduser),
+			Token:         token,
+			Refresh_Token: refreshToken,
+		})
+	}
+}
+
+// userTypeOf devuelve el rol del usuario. Los usuarios creados antes de que
+// existieran los roles se tratan como usuarios comunes.
+func userTypeOf(user models.User) string {
+	if user.User_Type == nil {
+		return models.USER_TYPE_USER
+	}
+	return *user.User_Type
+}
+
+// rehashPassword guarda un hash nuevo de la contraseña. Un fallo acá no impide el login,
+// solo se registra y se volverá a intentar la próxima vez.
+func rehashPassword(ctx context.Context, userCollection *mongo.Collection, userID string, password string) {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"password": hashed}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// RefreshToken recibe un refresh token y devuelve un par nuevo de la misma sesión, invalidando
+// el anterior. Si alguien presenta un refresh token que su sesión ya rotó asumimos que fue robado:
+// revocamos esa sesión y registramos el evento de seguridad. Las demás sesiones no se tocan.
+func (app *Application) RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		// luego de generar el token, vamos a actualizar todos los tokens.
-		// le pasaremos el token y el token y el id de usuario
-		generate.UpdateAllTokens(token, refreshToken, founduser.User_ID)
 
-		// Caso de que todo funcione bien, devolvemos un estado http de encontrado y el usuario encontrado
-		c.JSON(http.StatusFound, founduser)
+		var body struct {
+			Refresh_Token string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
+		}
+
+		claims, err := generate.ValidateRefreshToken(body.Refresh_Token)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		var founduser models.User
+		err = app.userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&founduser)
+		if err != nil {
+			apierrors.Respond(c, errInvalidRefreshToken)
+			return
+		}
+
+		token, refreshToken, err := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID, userTypeOf(founduser), claims.Session)
+		if err != nil {
+			apierrors.Respond(c, apierrors.Internal("could not generate tokens"))
+			return
+		}
+
+		// La rotación solo se aplica si este refresh token sigue siendo el vigente de su sesión
+		err = app.tokens.RotateSession(body.Refresh_Token, refreshToken)
+		if errors.Is(err, generate.ErrRefreshTokenReused) {
+			app.revokeTokenFamily(c, founduser.User_ID, claims.Session)
+			return
+		}
+		if errors.Is(err, generate.ErrSessionEnded) {
+			apierrors.Respond(c, err)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not update tokens"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
 
+// revokeTokenFamily responde a la reutilización de un refresh token ya rotado cerrando su sesión.
+func (app *Application) revokeTokenFamily(c *gin.Context, userID string, session string) {
+	log.Printf("SECURITY: refresh token reuse detected for user %s (session %s) from %s, revoking the session", userID, session, c.ClientIP())
+	if err := app.tokens.RevokeSession(userID, session); err != nil {
+		log.Printf("SECURITY: could not revoke session %s of user %s: %v", session, userID, err)
 	}
+	apierrors.Respond(c, generate.ErrRefreshTokenReused)
+}
+
+// Logout cierra solo la sesión del token con el que se hizo la solicitud; para cerrar todas está LogoutAll.
+func (app *Application) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := c.Get("claims")
+		if !ok {
+			apierrors.Respond(c, apierrors.ErrUnauthenticated)
+			return
+		}
+		details := claims.(*generate.SignedDetails)
 
+		if err := app.tokens.EndSession(details); err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not revoke token"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+	}
+}
+
+// LogoutAll revoca todos los tokens emitidos para el usuario, en cualquier dispositivo.
+func (app *Application) LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			apierrors.Respond(c, apierrors.ErrUnauthenticated)
+			return
+		}
+		if err := app.tokens.RevokeAllSessions(uid); err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not revoke sessions"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out from all sessions"})
+	}
+}
+
+// actingUserID devuelve el id del usuario sobre el que actúa la solicitud.
+// Por defecto es el usuario autenticado (uid que deja middleware.Authentication en el contexto).
+// Solo un ADMIN puede actuar en nombre de otro usuario pasando explícitamente ?on_behalf_of=<user_id>.
+func actingUserID(c *gin.Context) (string, error) {
+	uid := c.GetString("uid")
+	if uid == "" {
+		return "", apierrors.ErrUnauthenticated
+	}
+
+	onBehalfOf := c.Query("on_behalf_of")
+	if onBehalfOf == "" || onBehalfOf == uid {
+		return uid, nil
+	}
+	if c.GetString("user_type") != models.USER_TYPE_ADMIN {
+		return "", errOnBehalfNotAllowed
+	}
+	log.Printf("admin %s acting on behalf of user %s", uid, onBehalfOf)
+	return onBehalfOf, nil
 }
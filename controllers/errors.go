@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+)
+
+// Errores propios de los handlers, que no vienen del paquete database.
+// Se responden, como todos los demás, con apierrors.Respond.
+var (
+	errOnBehalfNotAllowed  = apierrors.New(http.StatusForbidden, "forbidden", "only admins can act on behalf of another user")
+	errInvalidCredentials  = apierrors.New(http.StatusUnauthorized, "invalid_credentials", "login or password incorrect")
+	errInvalidRefreshToken = apierrors.New(http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
+	errEmailTaken          = apierrors.New(http.StatusConflict, "email_taken", "user email already exist")
+	errPhoneTaken          = apierrors.New(http.StatusConflict, "phone_taken", "this phone no. is already in use")
+	errUserExists          = apierrors.New(http.StatusConflict, "user_exists", "user already exist")
+	errInvalidProductID    = apierrors.New(http.StatusBadRequest, "invalid_product_id", "product id is missing or invalid")
+	errInvalidOrderID      = apierrors.New(http.StatusBadRequest, "invalid_order_id", "invalid order id")
+	errInvalidAddressID    = apierrors.New(http.StatusBadRequest, "invalid_address_id", "invalid address id")
+	errInvalidUserID       = apierrors.New(http.StatusBadRequest, "invalid_user_id", "invalid user id")
+	errDefaultKindRequired = apierrors.New(http.StatusBadRequest, "default_kind_required", "shipping or billing must be true")
+	errInvalidSearchIndex  = apierrors.New(http.StatusBadRequest, "invalid_query", "invalid search index")
+	errSearchTextTooLong   = apierrors.New(http.StatusBadRequest, "invalid_query", "search text is too long")
+	errInvalidSearchMode   = apierrors.New(http.StatusBadRequest, "invalid_query", "mode must be regex or text")
+)
+
+// Los errores de database se registran acá porque apierrors no puede importar database:
+// importarlo abre la conexión a mongo, y apierrors lo usan también los middlewares.
+func init() {
+	for _, mapping := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{database.ErrCantFindProduct, http.StatusNotFound, "product_not_found"},
+		{database.ErrItemNotInCart, http.StatusNotFound, "cart_item_not_found"},
+		{database.ErrAddressNotFound, http.StatusNotFound, "address_not_found"},
+		{database.ErrOrderNotFound, http.StatusNotFound, "order_not_found"},
+
+		{database.ErrUserIdIsNotValid, http.StatusBadRequest, "invalid_user"},
+		{database.ErrCartIsEmpty, http.StatusBadRequest, "cart_empty"},
+		{database.ErrQuantityOutOfRange, http.StatusBadRequest, "quantity_out_of_range"},
+		{database.ErrNoShippingAddress, http.StatusBadRequest, "shipping_address_required"},
+		{database.ErrInvalidOrderStatus, http.StatusBadRequest, "invalid_order_status"},
+		{database.ErrUseCancelOrder, http.StatusBadRequest, "use_cancel_order"},
+
+		{database.ErrInsufficientStock, http.StatusConflict, "insufficient_stock"},
+		{database.ErrIllegalOrderTransition, http.StatusConflict, "illegal_order_transition"},
+		{database.ErrAddressLimitReached, http.StatusConflict, "address_limit_reached"},
+		{database.ErrAddressLabelTaken, http.StatusConflict, "address_label_taken"},
+
+		{database.ErrCantDecodeProducts, http.StatusInternalServerError, "product_decode_failed"},
+		{database.ErrCantUpdateUser, http.StatusInternalServerError, "cart_update_failed"},
+		{database.ErrCantRemoveItemCart, http.StatusInternalServerError, "cart_update_failed"},
+		{database.ErrCantGetItem, http.StatusInternalServerError, "cart_read_failed"},
+		{database.ErrCantBuyCartItem, http.StatusInternalServerError, "checkout_failed"},
+		{database.ErrCantUpdateAddress, http.StatusInternalServerError, "address_update_failed"},
+		{database.ErrCantUpdateOrder, http.StatusInternalServerError, "order_update_failed"},
+		{database.ErrCantGetOrder, http.StatusInternalServerError, "order_read_failed"},
+		{database.ErrCantAdjustStock, http.StatusInternalServerError, "stock_adjustment_failed"},
+	} {
+		apierrors.Register(mapping.err, mapping.status, mapping.code)
+	}
+}
+
+// bindError prepara el error de ShouldBindJSON para apierrors.Respond: los errores de validación
+// pasan tal cual (responden con fields) y el resto es un cuerpo que no se pudo leer.
+func bindError(err error) error {
+	if apierrors.FieldErrors(err) != nil {
+		return err
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return apierrors.New(http.StatusBadRequest, "invalid_body", "request body is not valid JSON for this endpoint")
+	}
+	return apierrors.New(http.StatusBadRequest, "invalid_body", err.Error())
+}
+
+// queryError convierte un error al leer los parámetros de la URL (paginación, filtros, orden) en un 400.
+func queryError(err error) error {
+	return apierrors.New(http.StatusBadRequest, "invalid_query", err.Error())
+}
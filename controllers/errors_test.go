@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestDatabaseErrorsRegistered revisa que los errores de database lleguen a apierrors con su código.
+func TestDatabaseErrorsRegistered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{database.ErrOrderNotFound, http.StatusNotFound, "order_not_found"},
+		{database.ErrQuantityOutOfRange, http.StatusBadRequest, "quantity_out_of_range"},
+		{&database.IllegalTransitionError{From: models.ORDER_STATUS_SHIPPED, To: models.ORDER_STATUS_PENDING}, http.StatusConflict, "illegal_order_transition"},
+		{&database.InsufficientStockError{ProductIDs: []primitive.ObjectID{primitive.NewObjectID()}}, http.StatusConflict, "insufficient_stock"},
+		{fmt.Errorf("%w: timeout", database.ErrCantBuyCartItem), http.StatusInternalServerError, "checkout_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantCode, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			apierrors.Respond(c, tt.err)
+
+			var body models.ErrorResponse
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			if recorder.Code != tt.wantStatus || body.Code != tt.wantCode {
+				t.Errorf("got %d %q, want %d %q", recorder.Code, body.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	apierrors.Respond(c, &database.InsufficientStockError{ProductIDs: []primitive.ObjectID{primitive.NewObjectID()}})
+	var body struct {
+		Details struct {
+			ProductIDs []string `json:"product_ids"`
+		} `json:"details"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if len(body.Details.ProductIDs) != 1 {
+		t.Errorf("insufficient stock details = %s, want one product id", recorder.Body.String())
+	}
+}
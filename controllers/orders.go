@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListOrders devuelve el historial de órdenes del usuario autenticado, de la más nueva a la más vieja.
+// Acepta ?page=N&limit=M, ?status=<estado> y un rango de fechas con ?from= y ?to=
+// (RFC3339 o YYYY-MM-DD; una fecha sola en "to" incluye todo ese día).
+func (app *Application) ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		pagination, err := parsePagination(c)
+		if err != nil {
+			apierrors.Respond(c, queryError(err))
+			return
+		}
+		filter, err := parseOrderFilter(c)
+		if err != nil {
+			apierrors.Respond(c, queryError(err))
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orders, total, err := database.UserOrders(ctx, app.orderCollection, userQueryID, filter, pagination.FindOptions())
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not list orders"))
+			return
+		}
+		setTotalCountHeader(c, total)
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+// GetOrder devuelve una orden del usuario autenticado con sus líneas, total, descuento,
+// medio de pago y dirección de envío. Las órdenes de otros usuarios responden 404.
+func (app *Application) GetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidOrderID)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.UserOrder(ctx, app.orderCollection, userQueryID, orderID)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// CancelOrderRequest es el cuerpo de CancelOrder.
+type CancelOrderRequest struct {
+	Reason string `json:"reason" binding:"required,max=200"`
+}
+
+// CancelOrder cancela una orden del usuario autenticado mientras esté pending o paid
+// (todavía no enviada), devuelve el stock de sus productos y registra el motivo.
+func (app *Application) CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userQueryID, err := actingUserID(c)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidOrderID)
+			return
+		}
+
+		var body CancelOrderRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := database.CancelOrder(ctx, app.prodCollection, app.orderCollection, orderID, userQueryID, c.GetString("uid"), body.Reason)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// parseOrderFilter lee los filtros del historial de órdenes.
+func parseOrderFilter(c *gin.Context) (database.OrderFilter, error) {
+	var filter database.OrderFilter
+
+	if value := c.Query("status"); value != "" {
+		filter.Status = models.OrderStatus(value)
+		if !filter.Status.IsValid() {
+			return filter, errors.New("invalid order status")
+		}
+	}
+
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseDate(value)
+		if err != nil {
+			return filter, errors.New("from must be a RFC3339 timestamp or a YYYY-MM-DD date")
+		}
+		filter.From = from
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseDate(value)
+		if err != nil {
+			return filter, errors.New("to must be a RFC3339 timestamp or a YYYY-MM-DD date")
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	return filter, nil
+}
+
+// parseDate acepta un timestamp RFC3339 o una fecha YYYY-MM-DD (en UTC).
+func parseDate(value string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse(time.DateOnly, value)
+	return t, true, err
+}
+
+// OrderStatusRequest es el cuerpo de UpdateOrderStatus.
+type OrderStatusRequest struct {
+	Status models.OrderStatus `json:"status" binding:"required"`
+	Reason string             `json:"reason" binding:"max=200"`
+}
+
+// UpdateOrderStatus avanza una orden a un nuevo estado (solo admins).
+// Un cambio que el ciclo de vida no permite (por ejemplo shipped -> pending) responde 409.
+func (app *Application) UpdateOrderStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidOrderID)
+			return
+		}
+
+		var body OrderStatusRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
+		}
+
+		// Cancelar además devuelve el stock, por eso va por CancelOrder
+		var order models.Order
+		if body.Status == models.ORDER_STATUS_CANCELLED {
+			order, err = database.CancelOrder(ctx, app.prodCollection, app.orderCollection, orderID, "", c.GetString("uid"), body.Reason)
+		} else {
+			order, err = database.TransitionOrder(ctx, app.orderCollection, orderID, body.Status, c.GetString("uid"), body.Reason)
+		}
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
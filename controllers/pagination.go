@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Límites de la paginación de los listados
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Pagination representa los parámetros ?page=N&limit=M de un listado.
+type Pagination struct {
+	Page  int64
+	Limit int64
+}
+
+// parsePagination lee page (desde 1) y limit (hasta maxPageLimit) del query string.
+func parsePagination(c *gin.Context) (Pagination, error) {
+	p := Pagination{Page: 1, Limit: defaultPageLimit}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || page < 1 {
+			return p, errors.New("page must be a positive number")
+		}
+		p.Page = page
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		p.Limit = limit
+	}
+	return p, nil
+}
+
+// FindOptions aplica la paginación a una consulta Find.
+func (p Pagination) FindOptions() *options.FindOptions {
+	return options.Find().SetSkip((p.Page - 1) * p.Limit).SetLimit(p.Limit)
+}
+
+// productSortFields son los campos por los que se puede ordenar el catálogo.
+// Los precios guardados como número suelto no tienen price.amount y quedan fuera del orden
+// hasta que se corra cmd/backfill-prices.
+var productSortFields = map[string]string{
+	"price":  "price.amount",
+	"rating": "rating",
+	"name":   "product_name",
+}
+
+// parseProductSort lee ?sort=price|rating|name; con un "-" adelante el orden es descendente.
+// Siempre agregamos _id al final para que el orden sea estable entre páginas.
+func parseProductSort(c *gin.Context) (bson.D, error) {
+	value := c.Query("sort")
+	if value == "" {
+		return bson.D{primitive.E{Key: "_id", Value: 1}}, nil
+	}
+
+	direction := 1
+	if strings.HasPrefix(value, "-") {
+		direction = -1
+		value = strings.TrimPrefix(value, "-")
+	}
+	field, ok := productSortFields[value]
+	if !ok {
+		return nil, errors.New("sort must be one of price, rating, name")
+	}
+	return bson.D{
+		primitive.E{Key: field, Value: direction},
+		primitive.E{Key: "_id", Value: 1},
+	}, nil
+}
+
+// setTotalCountHeader informa el total de resultados, así el cliente puede armar el paginado.
+func setTotalCountHeader(c *gin.Context, total int64) {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+}
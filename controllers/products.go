@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/database"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductViewAdmin permite a un administrador cargar un producto nuevo.
+// La ruta está dentro del grupo /admin, protegido por middleware.RequireAdmin.
+func ProductViewAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var products models.Products
+		if err := c.ShouldBindJSON(&products); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
+		}
+
+		// Validamos nombre, precio y rating con las reglas declaradas en models.Products
+		if validationErr := Validate.Struct(products); validationErr != nil {
+			apierrors.Respond(c, validationErr)
+			return
+		}
+
+		// El id siempre lo genera el servidor, aunque el cliente mande uno
+		products.Product_ID = primitive.NewObjectID()
+		// Sin stock informado el producto arranca sin unidades; se carga con AdjustStock
+		if products.Stock == nil {
+			var stock int64
+			products.Stock = &stock
+		}
+		_, err := ProductCollection.InsertOne(ctx, products)
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("the product did not get created"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, products)
+	}
+}
+
+// SearchProduct devuelve el catálogo de productos paginado.
+// Acepta ?page=N&limit=M y ?sort=price|rating|name (con "-" para orden descendente),
+// y devuelve el total de productos en el header X-Total-Count.
+func SearchProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		pagination, err := parsePagination(c)
+		if err != nil {
+			apierrors.Respond(c, queryError(err))
+			return
+		}
+		sort, err := parseProductSort(c)
+		if err != nil {
+			apierrors.Respond(c, queryError(err))
+			return
+		}
+
+		total, err := ProductCollection.CountDocuments(ctx, bson.D{})
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not count products"))
+			return
+		}
+
+		cursor, err := ProductCollection.Find(ctx, bson.D{}, pagination.FindOptions().SetSort(sort))
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not list products"))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		productlist := make([]models.Products, 0)
+		if err := cursor.All(ctx, &productlist); err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not decode products"))
+			return
+		}
+
+		setTotalCountHeader(c, total)
+		c.JSON(http.StatusOK, productlist)
+	}
+}
+
+// StockAdjustmentRequest es el cuerpo de AdjustStock: delta puede ser positivo (ingreso)
+// o negativo (baja), y reason queda en la auditoría.
+type StockAdjustmentRequest struct {
+	Delta  int64  `json:"delta" binding:"required"`
+	Reason string `json:"reason" binding:"required,max=200"`
+}
+
+// AdjustStock suma o resta unidades al stock de un producto (solo admins) y devuelve el ajuste registrado.
+func (app *Application) AdjustStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidProductID)
+			return
+		}
+
+		var body StockAdjustmentRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			apierrors.Respond(c, bindError(err))
+			return
+		}
+
+		adjustment, err := database.AdjustStock(ctx, app.prodCollection, app.stockAdjustmentCollection, productID, body.Delta, body.Reason, c.GetString("uid"))
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, adjustment)
+	}
+}
+
+// StockAdjustments lista la auditoría de ajustes de stock de un producto, paginada y del más nuevo al más viejo.
+func (app *Application) StockAdjustments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierrors.Respond(c, errInvalidProductID)
+			return
+		}
+		pagination, err := parsePagination(c)
+		if err != nil {
+			apierrors.Respond(c, queryError(err))
+			return
+		}
+
+		adjustments, total, err := database.StockAdjustments(ctx, app.stockAdjustmentCollection, productID, pagination.FindOptions())
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not list stock adjustments"))
+			return
+		}
+		setTotalCountHeader(c, total)
+		c.JSON(http.StatusOK, adjustments)
+	}
+}
+
+// maxSearchLength limita el largo del texto a buscar
+const maxSearchLength = 100
+
+// ProductSearchResult es un producto encontrado por la búsqueda. Score solo viene
+// cargado en el modo "text" y es la relevancia que calcula mongo.
+type ProductSearchResult struct {
+	models.Products `bson:",inline"`
+	Score           float64 `json:"score,omitempty" bson:"score,omitempty"`
+}
+
+// SearchProductByQuerie busca productos por nombre con ?name=<texto>, paginado igual que SearchProduct.
+// Por defecto busca el texto dentro del nombre sin distinguir mayúsculas (el texto se escapa, así
+// no se puede inyectar una expresión regular). Con ?mode=text usa el índice de texto de mongo y
+// ordena por relevancia.
+func SearchProductByQuerie() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		queryParam := strings.TrimSpace(c.Query("name"))
+		if queryParam == "" {
+			apierrors.Respond(c, errInvalidSearchIndex)
+			return
+		}
+		if len(queryParam) > maxSearchLength {
+			apierrors.Respond(c, errSearchTextTooLong)
+			return
+		}
+
+		pagination, err := parsePagination(c)
+		if err != nil {
+			apierrors.Respond(c, queryError(err))
+			return
+		}
+
+		var filter bson.D
+		findOptions := pagination.FindOptions()
+		switch c.DefaultQuery("mode", "regex") {
+		case "regex":
+			sort, err := parseProductSort(c)
+			if err != nil {
+				apierrors.Respond(c, queryError(err))
+				return
+			}
+			filter = bson.D{primitive.E{Key: "product_name", Value: primitive.Regex{Pattern: regexp.QuoteMeta(queryParam), Options: "i"}}}
+			findOptions.SetSort(sort)
+		case "text":
+			score := bson.D{primitive.E{Key: "$meta", Value: "textScore"}}
+			filter = bson.D{primitive.E{Key: "$text", Value: bson.D{primitive.E{Key: "$search", Value: queryParam}}}}
+			findOptions.SetProjection(bson.D{primitive.E{Key: "score", Value: score}})
+			findOptions.SetSort(bson.D{primitive.E{Key: "score", Value: score}, primitive.E{Key: "_id", Value: 1}})
+		default:
+			apierrors.Respond(c, errInvalidSearchMode)
+			return
+		}
+
+		total, err := ProductCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not count products"))
+			return
+		}
+
+		searchquerydb, err := ProductCollection.Find(ctx, filter, findOptions)
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("something went wrong while fetching the data"))
+			return
+		}
+		defer searchquerydb.Close(ctx)
+
+		searchproducts := make([]ProductSearchResult, 0)
+		if err := searchquerydb.All(ctx, &searchproducts); err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not decode products"))
+			return
+		}
+
+		setTotalCountHeader(c, total)
+		c.JSON(http.StatusOK, searchproducts)
+	}
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// countryFormat describe cómo se escriben los códigos postales y los teléfonos de un país.
+type countryFormat struct {
+	postalCode  *regexp.Regexp
+	callingCode string
+}
+
+// countryFormats son los países que conocemos, por código ISO 3166-1 alfa-2.
+// Para el resto se acepta cualquier código postal razonable y cualquier teléfono E.164.
+var countryFormats = map[string]countryFormat{
+	"AR": {regexp.MustCompile(`^([A-Z]\d{4}[A-Z]{3}|\d{4})$`), "54"},
+	"AU": {regexp.MustCompile(`^\d{4}$`), "61"},
+	"BR": {regexp.MustCompile(`^\d{5}-?\d{3}$`), "55"},
+	"CA": {regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`), "1"},
+	"CL": {regexp.MustCompile(`^\d{7}$`), "56"},
+	"DE": {regexp.MustCompile(`^\d{5}$`), "49"},
+	"ES": {regexp.MustCompile(`^\d{5}$`), "34"},
+	"FR": {regexp.MustCompile(`^\d{5}$`), "33"},
+	"GB": {regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`), "44"},
+	"IN": {regexp.MustCompile(`^\d{6}$`), "91"},
+	"IT": {regexp.MustCompile(`^\d{5}$`), "39"},
+	"JP": {regexp.MustCompile(`^\d{3}-?\d{4}$`), "81"},
+	"MX": {regexp.MustCompile(`^\d{5}$`), "52"},
+	"NL": {regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`), "31"},
+	"US": {regexp.MustCompile(`^\d{5}(-\d{4})?$`), "1"},
+	"UY": {regexp.MustCompile(`^\d{5}$`), "598"},
+}
+
+var (
+	genericPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`)
+	e164Phone         = regexp.MustCompile(`^\+[1-9]\d{6,14}$`)
+	phoneSeparators   = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+)
+
+// newValidator crea el validador compartido por los handlers: los errores usan el nombre
+// json de cada campo y se agregan las reglas propias:
+//
+//	postal_code=<campo>  código postal válido para el país guardado en <campo> (su nombre json)
+//	phone                teléfono en formato E.164 (+<código de país><número>)
+//	phone=<campo>        además, con el código de país del país guardado en <campo>
+//	max_bytes=<n>        a lo sumo n bytes en UTF-8 (max cuenta caracteres); bcrypt no admite más de 72
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonFieldName)
+	if err := v.RegisterValidation("postal_code", validatePostalCode); err != nil {
+		log.Fatal(err)
+	}
+	if err := v.RegisterValidation("phone", validatePhone); err != nil {
+		log.Fatal(err)
+	}
+	if err := v.RegisterValidation("max_bytes", validateMaxBytes); err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+// Los cuerpos que se leen con ShouldBindJSON se validan con el validador de gin (tags "binding");
+// le damos el mismo nombre de campos para que sus errores salgan igual que los de Validate.
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+}
+
+// jsonFieldName hace que los errores nombren los campos como los ve el cliente.
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// countryOf lee el país del campo indicado en el parámetro de la regla. El parámetro es el nombre
+// json del campo, así el Param de los errores nombra el campo igual que el cliente.
+func countryOf(fl validator.FieldLevel) (string, bool) {
+	parent := reflect.Indirect(fl.Parent())
+	if parent.Kind() != reflect.Struct {
+		return "", false
+	}
+	for i := 0; i < parent.NumField(); i++ {
+		if jsonFieldName(parent.Type().Field(i)) != fl.Param() {
+			continue
+		}
+		field := reflect.Indirect(parent.Field(i))
+		if field.Kind() != reflect.String {
+			return "", false
+		}
+		return strings.ToUpper(field.String()), true
+	}
+	return "", false
+}
+
+func validatePostalCode(fl validator.FieldLevel) bool {
+	code := strings.ToUpper(strings.TrimSpace(fl.Field().String()))
+	country, _ := countryOf(fl)
+	if format, ok := countryFormats[country]; ok {
+		return format.postalCode.MatchString(code)
+	}
+	return genericPostalCode.MatchString(code)
+}
+
+func validatePhone(fl validator.FieldLevel) bool {
+	phone := fl.Field().String()
+	if !e164Phone.MatchString(phone) {
+		return false
+	}
+	if fl.Param() == "" {
+		return true
+	}
+	country, _ := countryOf(fl)
+	if format, ok := countryFormats[country]; ok {
+		return strings.HasPrefix(phone, "+"+format.callingCode)
+	}
+	return true
+}
+
+func validateMaxBytes(fl validator.FieldLevel) bool {
+	limit, err := strconv.Atoi(fl.Param())
+	if err != nil {
+		return false
+	}
+	return len(fl.Field().String()) <= limit
+}
+
+// normalizePhone quita los separadores que suele escribir la gente ("+54 9 (11) 1234-5678").
+func normalizePhone(phone *string) {
+	if phone != nil {
+		*phone = phoneSeparators.Replace(strings.TrimSpace(*phone))
+	}
+}
+
+// normalizeAddress deja el país en mayúsculas y el teléfono sin separadores antes de validar.
+func normalizeAddress(address *models.Address) {
+	if address.Country != nil {
+		country := strings.ToUpper(strings.TrimSpace(*address.Country))
+		address.Country = &country
+	}
+	if address.Pincode != nil {
+		pincode := strings.ToUpper(strings.TrimSpace(*address.Pincode))
+		address.Pincode = &pincode
+	}
+	normalizePhone(address.Phone)
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+)
+
+// address arma una dirección con todos los campos obligatorios; phone vacío la deja sin teléfono.
+func address(country, pincode, phone string) models.Address {
+	house, street, city := "12", "Main", "Springfield"
+	a := models.Address{House: &house, Street: &street, City: &city, Pincode: &pincode, Country: &country}
+	if phone != "" {
+		a.Phone = &phone
+	}
+	return a
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address models.Address
+		// want son los errores esperados como "campo:regla"
+		want []string
+	}{
+		{"AR new postal code", address("AR", "C1002AAR", "+5491112345678"), nil},
+		{"AR old postal code", address("ar", "1425", ""), nil},
+		{"AR phone with separators", address("AR", "c1002aar", "+54 9 (11) 1234-5678"), nil},
+		{"AR US-style postal code", address("AR", "94105", ""), []string{"pin_code:postal_code"}},
+		{"AR phone from another country", address("AR", "1425", "+14155552671"), []string{"phone:phone"}},
+		{"GB postal code", address("GB", "SW1A 1AA", "+442071234567"), nil},
+		{"GB postal code without space", address("gb", "m11ae", ""), nil},
+		{"GB numeric postal code", address("GB", "12345", ""), []string{"pin_code:postal_code"}},
+		{"US ZIP", address("US", "94105", "+14155552671"), nil},
+		{"US ZIP+4", address("US", "94105-1234", ""), nil},
+		{"US short ZIP", address("US", "9410", ""), []string{"pin_code:postal_code"}},
+		{"US phone without country code", address("US", "94105", "4155552671"), []string{"phone:phone"}},
+		{"CA postal code", address("CA", "K1A 0B1", "+16135550123"), nil},
+		{"CA postal code without space", address("ca", "k1a0b1", ""), nil},
+		{"CA US ZIP", address("CA", "94105", ""), []string{"pin_code:postal_code"}},
+		{"CA phone from another country", address("CA", "K1A 0B1", "+5491112345678"), []string{"phone:phone"}},
+		{"country without a known format", address("PT", "1000-001", "+351212345678"), nil},
+		{"country without a known format and bad postal code", address("PT", "!!", ""), []string{"pin_code:postal_code"}},
+		{"unknown country", address("ZZ", "12345", ""), []string{"country:iso3166_1_alpha2"}},
+		{"country name instead of code", address("Argentina", "1425", ""), []string{"country:iso3166_1_alpha2"}},
+		{"missing fields", models.Address{}, []string{
+			"house_name:required", "street_name:required", "city_name:required", "pin_code:required", "country:required",
+		}},
+		{"unknown label", func() models.Address {
+			a := address("AR", "1425", "")
+			a.Label = "summer"
+			return a
+		}(), []string{"label:oneof"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizeAddress(&tt.address)
+			err := Validate.Struct(tt.address)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate.Struct() = %v, want no error", err)
+				}
+				return
+			}
+
+			fields := apierrors.FieldErrors(err)
+			got := make(map[string]bool, len(fields))
+			for _, field := range fields {
+				if field.Message == "" {
+					t.Errorf("%s:%s has no message", field.Field, field.Rule)
+				}
+				got[field.Field+":"+field.Rule] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("apierrors.FieldErrors() = %v, want %v", fields, tt.want)
+			}
+			for _, want := range tt.want {
+				if !got[want] {
+					t.Errorf("missing %s in %v", want, fields)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldErrorsMessages(t *testing.T) {
+	a := address("AR", "94105", "+14155552671")
+	fields := apierrors.FieldErrors(Validate.Struct(a))
+	want := map[string]string{
+		"pin_code": "is not a valid postal code for the selected country",
+		"phone":    "must be a phone number of the selected country in E.164 format (e.g. +5491112345678)",
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("apierrors.FieldErrors() = %v, want %d errors", fields, len(want))
+	}
+	for _, field := range fields {
+		if field.Param != "country" {
+			t.Errorf("%s param = %q, want \"country\"", field.Field, field.Param)
+		}
+		if field.Message != want[field.Field] {
+			t.Errorf("%s message = %q, want %q", field.Field, field.Message, want[field.Field])
+		}
+	}
+}
+
+func TestValidatePasswordLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantRule string
+	}{
+		{"ascii", "secret", ""},
+		{"72 ascii bytes", strings.Repeat("a", 72), ""},
+		{"73 ascii bytes", strings.Repeat("a", 73), "max_bytes"},
+		// ñ ocupa 2 bytes: 37 caracteres pasan max=72 pero son 74 bytes para bcrypt
+		{"72 bytes of multibyte characters", strings.Repeat("ñ", 36), ""},
+		{"74 bytes in 37 characters", strings.Repeat("ñ", 37), "max_bytes"},
+		{"too short", "abc", "min"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			password := tt.password
+			err := Validate.StructPartial(models.User{Password: &password}, "Password")
+			fields := apierrors.FieldErrors(err)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Fatalf("Validate.StructPartial() = %v, want no error", err)
+				}
+				return
+			}
+			if len(fields) != 1 || fields[0].Field != "password" || fields[0].Rule != tt.wantRule {
+				t.Fatalf("apierrors.FieldErrors() = %v, want password:%s", fields, tt.wantRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Errores de la libreta de direcciones
+var (
+	ErrAddressNotFound     = errors.New("address not found")
+	ErrAddressLimitReached = errors.New("the address book is full")
+	ErrCantUpdateAddress   = errors.New("cannot update the address")
+	ErrAddressLabelTaken   = errors.New("there is already an address with this label")
+	ErrNoShippingAddress   = errors.New("no shipping address selected: pass address_id or set a default shipping address")
+)
+
+// MaxAddresses es la cantidad máxima de direcciones que puede guardar un usuario
+const MaxAddresses = 5
+
+// AddressDefault es el tipo de dirección por defecto: de envío o de facturación.
+// Su valor es el campo de models.Address que la marca.
+type AddressDefault string
+
+const (
+	DefaultShipping AddressDefault = "default_shipping"
+	DefaultBilling  AddressDefault = "default_billing"
+)
+
+// Addresses devuelve las direcciones guardadas del usuario.
+func Addresses(ctx context.Context, userCollection *mongo.Collection, userID string) ([]models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+
+	var user struct {
+		Address_Details []models.Address `bson:"address"`
+	}
+	findOptions := options.FindOne().SetProjection(bson.D{primitive.E{Key: "address", Value: 1}})
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}, findOptions).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserIdIsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateAddress
+	}
+	if user.Address_Details == nil {
+		user.Address_Details = make([]models.Address, 0)
+	}
+	return user.Address_Details, nil
+}
+
+// AddAddress agrega una dirección al final de la libreta del usuario con un id nuevo.
+// El filtro exige que la posición MaxAddresses-1 no exista, así el límite se respeta
+// aunque lleguen dos solicitudes a la vez; por el mismo motivo el filtro también rechaza
+// una segunda dirección home o work. Sin etiqueta la dirección queda como other.
+// Si la dirección pide ser la de envío o facturación por defecto, se desmarca la anterior.
+func AddAddress(ctx context.Context, userCollection *mongo.Collection, userID string, address models.Address) (models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return address, ErrUserIdIsNotValid
+	}
+
+	if address.Label == "" {
+		address.Label = models.ADDRESS_LABEL_OTHER
+	}
+	defaults := address
+	address.Address_id = primitive.NewObjectID()
+	address.Default_Shipping, address.Default_Billing = false, false
+
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: fmt.Sprintf("address.%d", MaxAddresses-1), Value: bson.D{primitive.E{Key: "$exists", Value: false}}},
+	}
+	if address.Label != models.ADDRESS_LABEL_OTHER {
+		filter = append(filter, primitive.E{Key: "address.label", Value: bson.D{primitive.E{Key: "$ne", Value: address.Label}}})
+	}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: address}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return address, ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		return address, addAddressError(ctx, userCollection, userID, address.Label)
+	}
+
+	if defaults.Default_Shipping {
+		if err := SetDefaultAddress(ctx, userCollection, userID, address.Address_id, DefaultShipping); err != nil {
+			return address, err
+		}
+		address.Default_Shipping = true
+	}
+	if defaults.Default_Billing {
+		if err := SetDefaultAddress(ctx, userCollection, userID, address.Address_id, DefaultBilling); err != nil {
+			return address, err
+		}
+		address.Default_Billing = true
+	}
+	return address, nil
+}
+
+// addAddressError explica por qué AddAddress no pudo agregar la dirección.
+func addAddressError(ctx context.Context, userCollection *mongo.Collection, userID string, label models.AddressLabel) error {
+	addresses, err := Addresses(ctx, userCollection, userID)
+	if err != nil {
+		return err
+	}
+	if len(addresses) >= MaxAddresses {
+		return ErrAddressLimitReached
+	}
+	for _, address := range addresses {
+		if address.Label == label {
+			return ErrAddressLabelTaken
+		}
+	}
+	// La libreta cambió entre el update y la lectura
+	return ErrCantUpdateAddress
+}
+
+// UpdateAddressByLabel reemplaza los datos de la dirección con la etiqueta indicada (home o work),
+// conservando su id, su etiqueta y las marcas de dirección por defecto.
+// Antes de las etiquetas la home era la dirección en la posición 0 y la work la de la posición 1;
+// si el usuario no tiene ninguna dirección con la etiqueta se usa esa posición, siempre que la
+// dirección que está ahí no tenga etiqueta, y en el mismo update queda etiquetada.
+func UpdateAddressByLabel(ctx context.Context, userCollection *mongo.Collection, userID string, label models.AddressLabel, address models.Address) (models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return address, ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "address.label", Value: label}}
+	update := bson.D{{Key: "$set", Value: addressFields("address.$", address)}}
+	updated, err := updateAddressBook(ctx, userCollection, filter, update)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if position, ok := legacyAddressPositions[label]; ok {
+			slot := fmt.Sprintf("address.%d", position)
+			filter := bson.D{
+				primitive.E{Key: "_id", Value: id},
+				primitive.E{Key: "address.label", Value: bson.D{primitive.E{Key: "$ne", Value: label}}},
+				primitive.E{Key: slot, Value: bson.D{primitive.E{Key: "$exists", Value: true}}},
+				primitive.E{Key: slot + ".label", Value: bson.D{primitive.E{Key: "$in", Value: bson.A{nil, ""}}}},
+			}
+			fields := append(addressFields(slot, address), primitive.E{Key: slot + ".label", Value: label})
+			updated, err = updateAddressBook(ctx, userCollection, filter, bson.D{{Key: "$set", Value: fields}})
+		}
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return address, missingUserOr(ctx, userCollection, id, ErrAddressNotFound)
+	}
+	if err != nil {
+		log.Println(err)
+		return address, ErrCantUpdateAddress
+	}
+	for _, stored := range updated {
+		if stored.Label == label {
+			return stored, nil
+		}
+	}
+	return address, ErrAddressNotFound
+}
+
+// legacyAddressPositions es dónde guardaban la home y la work los usuarios anteriores a las etiquetas.
+var legacyAddressPositions = map[models.AddressLabel]int{
+	models.ADDRESS_LABEL_HOME: 0,
+	models.ADDRESS_LABEL_WORK: 1,
+}
+
+// addressFields arma el $set de los datos editables de la dirección que está en path
+// (por ejemplo "address.$" o "address.0").
+func addressFields(path string, address models.Address) bson.D {
+	return bson.D{
+		primitive.E{Key: path + ".house_name", Value: address.House},
+		primitive.E{Key: path + ".street_name", Value: address.Street},
+		primitive.E{Key: path + ".city_name", Value: address.City},
+		primitive.E{Key: path + ".pin_code", Value: address.Pincode},
+		primitive.E{Key: path + ".country", Value: address.Country},
+		primitive.E{Key: path + ".phone", Value: address.Phone},
+	}
+}
+
+// updateAddressBook aplica el update y devuelve la libreta como quedó.
+func updateAddressBook(ctx context.Context, userCollection *mongo.Collection, filter bson.D, update bson.D) ([]models.Address, error) {
+	var updated struct {
+		Address_Details []models.Address `bson:"address"`
+	}
+	findOptions := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{primitive.E{Key: "address", Value: 1}})
+	err := userCollection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&updated)
+	return updated.Address_Details, err
+}
+
+// SetDefaultAddress marca la dirección como la de envío o facturación por defecto
+// y desmarca la que lo era hasta ahora, en un único update.
+func SetDefaultAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID, kind AddressDefault) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "address._id", Value: addressID}}
+	isSelected := bson.D{primitive.E{Key: "$eq", Value: bson.A{"$$item._id", addressID}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: bson.D{primitive.E{Key: "$map", Value: bson.D{
+		primitive.E{Key: "input", Value: "$address"},
+		primitive.E{Key: "as", Value: "item"},
+		primitive.E{Key: "in", Value: bson.D{primitive.E{Key: "$mergeObjects", Value: bson.A{
+			"$$item",
+			bson.D{primitive.E{Key: string(kind), Value: isSelected}},
+		}}}},
+	}}}}}}}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		return missingUserOr(ctx, userCollection, id, ErrAddressNotFound)
+	}
+	return nil
+}
+
+// orderAddresses elige las direcciones de envío y facturación de una compra y devuelve una
+// copia de cada una. El envío va a addressID si se indicó, si no a la dirección de envío
+// por defecto, y si el usuario tiene una sola dirección, a esa. La facturación usa la
+// dirección de facturación por defecto o, si no hay, la de envío.
+func orderAddresses(addresses []models.Address, addressID primitive.ObjectID) (shipping, billing *models.Address, err error) {
+	var selected, defaultShipping, defaultBilling *models.Address
+	for i := range addresses {
+		address := &addresses[i]
+		if !addressID.IsZero() && address.Address_id == addressID {
+			selected = address
+		}
+		if address.Default_Shipping && defaultShipping == nil {
+			defaultShipping = address
+		}
+		if address.Default_Billing && defaultBilling == nil {
+			defaultBilling = address
+		}
+	}
+
+	switch {
+	case !addressID.IsZero() && selected == nil:
+		return nil, nil, ErrAddressNotFound
+	case selected == nil && defaultShipping != nil:
+		selected = defaultShipping
+	case selected == nil && len(addresses) == 1:
+		selected = &addresses[0]
+	case selected == nil:
+		return nil, nil, ErrNoShippingAddress
+	}
+	if defaultBilling == nil {
+		defaultBilling = selected
+	}
+	return selected.Snapshot(), defaultBilling.Snapshot(), nil
+}
+
+// DeleteAddress quita de la libreta la dirección con el id indicado.
+func DeleteAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "address._id", Value: addressID}}
+	update := bson.D{{Key: "$pull", Value: bson.D{primitive.E{Key: "address", Value: bson.D{primitive.E{Key: "_id", Value: addressID}}}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		return missingUserOr(ctx, userCollection, id, ErrAddressNotFound)
+	}
+	return nil
+}
+
+// missingUserOr distingue por qué un update condicional no encontró el documento:
+// si el usuario no existe devuelve ErrUserIdIsNotValid, si existe devuelve err.
+func missingUserOr(ctx context.Context, userCollection *mongo.Collection, id primitive.ObjectID, err error) error {
+	count, countErr := userCollection.CountDocuments(ctx, bson.D{primitive.E{Key: "_id", Value: id}})
+	if countErr != nil {
+		log.Println(countErr)
+		return ErrCantUpdateAddress
+	}
+	if count == 0 {
+		return ErrUserIdIsNotValid
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// legacyAddress es una dirección guardada antes de las etiquetas, sin el campo label.
+func legacyAddress(city string) bson.D {
+	return bson.D{
+		primitive.E{Key: "_id", Value: primitive.NewObjectID()},
+		primitive.E{Key: "house_name", Value: "12"},
+		primitive.E{Key: "street_name", Value: "Rivadavia"},
+		primitive.E{Key: "city_name", Value: city},
+		primitive.E{Key: "pin_code", Value: "1425"},
+	}
+}
+
+func TestUpdateAddressByLabelLegacyPositions(t *testing.T) {
+	db := standaloneDatabase(t)
+	users := db.Collection("Users")
+	ctx := context.Background()
+
+	insertUser := func(addresses ...interface{}) string {
+		t.Helper()
+		id := primitive.NewObjectID()
+		_, err := users.InsertOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "address", Value: bson.A(addresses)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id.Hex()
+	}
+	other := append(legacyAddress("Rosario"), primitive.E{Key: "label", Value: models.ADDRESS_LABEL_OTHER})
+	labelledHome := append(legacyAddress("Mendoza"), primitive.E{Key: "label", Value: models.ADDRESS_LABEL_HOME})
+
+	tests := []struct {
+		name     string
+		userID   string
+		label    models.AddressLabel
+		wantErr  error
+		position int
+	}{
+		{"home at position 0", insertUser(legacyAddress("Córdoba"), legacyAddress("Salta")), models.ADDRESS_LABEL_HOME, nil, 0},
+		{"work at position 1", insertUser(legacyAddress("Córdoba"), legacyAddress("Salta")), models.ADDRESS_LABEL_WORK, nil, 1},
+		{"no address at position 1", insertUser(legacyAddress("Córdoba")), models.ADDRESS_LABEL_WORK, ErrAddressNotFound, 0},
+		{"position 0 has another label", insertUser(other), models.ADDRESS_LABEL_HOME, ErrAddressNotFound, 0},
+		{"labelled address wins over position", insertUser(legacyAddress("Córdoba"), labelledHome), models.ADDRESS_LABEL_HOME, nil, 1},
+		{"unknown user", primitive.NewObjectID().Hex(), models.ADDRESS_LABEL_HOME, ErrUserIdIsNotValid, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			house, street, city, pincode, country := "500", "San Martín", "Tucumán", "T4000", "AR"
+			edit := models.Address{House: &house, Street: &street, City: &city, Pincode: &pincode, Country: &country}
+
+			updated, err := UpdateAddressByLabel(ctx, users, tt.userID, tt.label, edit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateAddressByLabel() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if updated.Label != tt.label || updated.City == nil || *updated.City != city {
+				t.Errorf("UpdateAddressByLabel() = %+v, want label %s and city %s", updated, tt.label, city)
+			}
+
+			stored, err := Addresses(ctx, users, tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored[tt.position].Address_id != updated.Address_id {
+				t.Errorf("updated the address at another position, want %d", tt.position)
+			}
+			// Una segunda edición ya encuentra la dirección por su etiqueta
+			if _, err := UpdateAddressByLabel(ctx, users, tt.userID, tt.label, edit); err != nil {
+				t.Errorf("second UpdateAddressByLabel() error = %v", err)
+			}
+		})
+	}
+}
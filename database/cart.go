@@ -1 +1,461 @@
 package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Errores que devuelven las operaciones del carrito. Los handlers los comparan con errors.Is
+// para elegir el código HTTP; el error original de mongo solo se registra en el log.
+var (
+	ErrCantFindProduct    = errors.New("can't find the product")
+	ErrCantDecodeProducts = errors.New("can't decode the product")
+	ErrUserIdIsNotValid   = errors.New("this user is not valid")
+	ErrCantUpdateUser     = errors.New("cannot add this product to the cart")
+	ErrCantRemoveItemCart = errors.New("cannot remove this item from the cart")
+	ErrCantGetItem        = errors.New("was unable to get the item from the cart")
+	ErrCantBuyCartItem    = errors.New("cannot update the purchase")
+	ErrCartIsEmpty        = errors.New("the cart is empty")
+	ErrItemNotInCart      = errors.New("the product is not in the cart")
+	ErrQuantityOutOfRange = errors.New("the quantity is out of range")
+)
+
+// MaxCartQuantity es la cantidad máxima de unidades de un mismo producto en el carrito
+const MaxCartQuantity = 99
+
+// findProduct busca el producto y lo convierte en una línea de carrito.
+func findProduct(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID) (models.ProductUser, error) {
+	var product models.ProductUser
+	err := prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return product, ErrCantFindProduct
+	}
+	if isTransactionError(err) {
+		return product, err
+	}
+	if err != nil {
+		log.Println(err)
+		return product, ErrCantDecodeProducts
+	}
+	return product, nil
+}
+
+func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+	// Buscamos el producto en la colección de productos
+	product, err := findProduct(ctx, prodCollection, productID)
+	if err != nil {
+		return err
+	}
+
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	if err := checkCartCurrency(ctx, userCollection, id, product.Price.Currency); err != nil {
+		return err
+	}
+
+	// Si el producto ya está en el carrito sumamos una unidad a esa línea
+	incremented, err := incrementCartItem(ctx, userCollection, id, productID)
+	if err != nil {
+		return err
+	}
+	if incremented {
+		return nil
+	}
+
+	// Si no está, agregamos una línea nueva con cantidad 1. El filtro con $ne evita
+	// duplicar la línea si otra solicitud la agregó mientras tanto.
+	product.Quantity = 1
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "usercart._id", Value: bson.D{primitive.E{Key: "$ne", Value: productID}}}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: product}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// No hubo match: o el usuario no existe o la línea apareció entre los dos pasos
+	incremented, err = incrementCartItem(ctx, userCollection, id, productID)
+	if err != nil {
+		return err
+	}
+	if !incremented {
+		return ErrUserIdIsNotValid
+	}
+	return nil
+}
+
+// checkCartCurrency verifica que el carrito no tenga productos en otra moneda,
+// ya que el total de la orden se calcula en una sola.
+func checkCartCurrency(ctx context.Context, userCollection *mongo.Collection, userID primitive.ObjectID, currency string) error {
+	var user struct {
+		UserCart []models.ProductUser `bson:"usercart"`
+	}
+	findOptions := options.FindOne().SetProjection(bson.D{primitive.E{Key: "usercart.price", Value: 1}})
+	err := userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userID}}, findOptions).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserIdIsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	for _, item := range user.UserCart {
+		if item.Price.Currency != currency {
+			return fmt.Errorf("%w: the cart already has products priced in %s", models.ErrCurrencyMismatch, item.Price.Currency)
+		}
+	}
+	return nil
+}
+
+// incrementCartItem suma una unidad a la línea del producto, si existe en el carrito.
+// Usamos un update con pipeline para que las líneas viejas sin quantity pasen de 1 a 2.
+// Si la línea ya tiene MaxCartQuantity unidades no se toca y devuelve ErrQuantityOutOfRange.
+func incrementCartItem(ctx context.Context, userCollection *mongo.Collection, userID, productID primitive.ObjectID) (bool, error) {
+	atLimit := bson.D{primitive.E{Key: "$gte", Value: MaxCartQuantity}}
+	// $not también deja pasar las líneas viejas sin quantity
+	filter := bson.D{primitive.E{Key: "_id", Value: userID}, primitive.E{Key: "usercart", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{
+		primitive.E{Key: "_id", Value: productID},
+		primitive.E{Key: "quantity", Value: bson.D{primitive.E{Key: "$not", Value: atLimit}}},
+	}}}}}
+	increment := bson.D{primitive.E{Key: "$add", Value: bson.A{bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$$item.quantity", 1}}}, 1}}}
+	newItem := bson.D{primitive.E{Key: "$mergeObjects", Value: bson.A{"$$item", bson.D{primitive.E{Key: "quantity", Value: increment}}}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{primitive.E{Key: "$map", Value: bson.D{
+		primitive.E{Key: "input", Value: "$usercart"},
+		primitive.E{Key: "as", Value: "item"},
+		primitive.E{Key: "in", Value: bson.D{primitive.E{Key: "$cond", Value: bson.A{
+			bson.D{primitive.E{Key: "$eq", Value: bson.A{"$$item._id", productID}}},
+			newItem,
+			"$$item",
+		}}}},
+	}}}}}}}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantUpdateUser
+	}
+	if result.MatchedCount > 0 {
+		return true, nil
+	}
+
+	// Sin match: o la línea no existe o ya llegó al máximo
+	full, err := userCollection.CountDocuments(ctx, bson.D{primitive.E{Key: "_id", Value: userID}, primitive.E{Key: "usercart", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{
+		primitive.E{Key: "_id", Value: productID},
+		primitive.E{Key: "quantity", Value: atLimit},
+	}}}}})
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantUpdateUser
+	}
+	if full > 0 {
+		return false, ErrQuantityOutOfRange
+	}
+	return false, nil
+}
+
+// SetCartItemQuantity fija la cantidad de una línea del carrito. Con cantidad 0 la línea se quita.
+func SetCartItemQuantity(ctx context.Context, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, quantity int) error {
+	if quantity < 0 || quantity > MaxCartQuantity {
+		return ErrQuantityOutOfRange
+	}
+	if quantity == 0 {
+		return RemoveCartItem(ctx, nil, userCollection, productID, userID)
+	}
+
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "usercart._id", Value: productID}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart.$.quantity", Value: quantity}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrItemNotInCart
+	}
+	return nil
+}
+
+func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	// $pull saca del array todas las líneas con ese producto. El filtro exige que el producto
+	// esté en el carrito, así quitar algo que no está responde ErrItemNotInCart
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "usercart._id", Value: productID}}
+	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantRemoveItemCart
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Sin coincidencias puede faltar el usuario o solo el producto en su carrito
+	count, err := userCollection.CountDocuments(ctx, bson.D{primitive.E{Key: "_id", Value: id}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantRemoveItemCart
+	}
+	if count == 0 {
+		return ErrUserIdIsNotValid
+	}
+	return ErrItemNotInCart
+}
+
+// BuyItemFromCart convierte el carrito del usuario en una orden de la colección Orders,
+// descuenta el stock de cada producto y vacía el carrito. Todo eso tiene que ser atómico:
+// lo hacemos en una transacción, y si el servidor no las soporta (no es un replica set)
+// seguimos los mismos pasos compensando a mano lo ya hecho cuando uno falla.
+// La orden guarda una copia de la dirección de envío (addressID, o la elegida por defecto
+// si es primitive.NilObjectID) y de la de facturación.
+func BuyItemFromCart(ctx context.Context, prodCollection, userCollection, orderCollection *mongo.Collection, userID string, addressID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	err = runInTransaction(ctx, userCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		return buyCartInTransaction(sessCtx, prodCollection, userCollection, orderCollection, id, addressID)
+	})
+	if errors.Is(err, errTransactionsNotSupported) {
+		return buyCartWithCompensation(ctx, prodCollection, userCollection, orderCollection, id, addressID)
+	}
+	return err
+}
+
+func buyCartInTransaction(ctx mongo.SessionContext, prodCollection, userCollection, orderCollection *mongo.Collection, id, addressID primitive.ObjectID) error {
+	// Leemos el carrito del usuario
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserIdIsNotValid
+	}
+	if isTransactionError(err) {
+		return err
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantGetItem
+	}
+	if len(user.UserCart) == 0 {
+		return ErrCartIsEmpty
+	}
+	order, err := newOrder(id, user.UserCart, user.Address_Details, addressID)
+	if err != nil {
+		return err
+	}
+
+	// Descontamos el stock; si algo falla la transacción se aborta y nada queda descontado
+	if _, err := reserveCartStock(ctx, prodCollection, user.UserCart); err != nil {
+		return checkoutError(err)
+	}
+
+	// Guardamos la orden con el contenido del carrito y su total
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		return checkoutError(err)
+	}
+
+	// Vaciamos el carrito
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: make([]models.ProductUser, 0)}}}}
+	if _, err := userCollection.UpdateOne(ctx, filter, update); err != nil {
+		return checkoutError(err)
+	}
+	return nil
+}
+
+// buyCartWithCompensation hace el checkout sin transacciones. Como la orden y el carrito
+// viven en colecciones distintas ya no alcanza con un único update: reservamos el stock,
+// guardamos la orden y recién después vaciamos el carrito, exigiendo que siga siendo
+// exactamente el que leímos. Si un paso falla deshacemos los anteriores. Si el proceso
+// muere a mitad de camino queda una orden válida con su stock descontado y el carrito
+// todavía lleno, nunca un carrito vacío sin orden.
+func buyCartWithCompensation(ctx context.Context, prodCollection, userCollection, orderCollection *mongo.Collection, id, addressID primitive.ObjectID) error {
+	// Guardamos el carrito tal cual está en la base de datos (bson.Raw) para poder compararlo después
+	var snapshot struct {
+		UserCart        []bson.Raw       `bson:"usercart"`
+		Address_Details []models.Address `bson:"address"`
+	}
+	findOptions := options.FindOne().SetProjection(bson.D{primitive.E{Key: "usercart", Value: 1}, primitive.E{Key: "address", Value: 1}})
+	err := userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}, findOptions).Decode(&snapshot)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserIdIsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantGetItem
+	}
+	if len(snapshot.UserCart) == 0 {
+		return ErrCartIsEmpty
+	}
+
+	cart := make([]models.ProductUser, len(snapshot.UserCart))
+	for i, raw := range snapshot.UserCart {
+		if err := bson.Unmarshal(raw, &cart[i]); err != nil {
+			log.Println(err)
+			return ErrCantGetItem
+		}
+	}
+
+	order, err := newOrder(id, cart, snapshot.Address_Details, addressID)
+	if err != nil {
+		return err
+	}
+
+	reserved, err := reserveCartStock(ctx, prodCollection, cart)
+	if err != nil {
+		releaseCartStock(ctx, prodCollection, reserved)
+		return checkoutError(err)
+	}
+
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		releaseCartStock(ctx, prodCollection, reserved)
+		return checkoutError(err)
+	}
+
+	// Solo vaciamos el carrito si es el mismo sobre el que armamos la orden
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, primitive.E{Key: "usercart", Value: snapshot.UserCart}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: make([]models.ProductUser, 0)}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount > 0 {
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+	} else {
+		log.Printf("cart of user %s changed during checkout", id.Hex())
+	}
+
+	// No pudimos vaciar el carrito: deshacemos la orden y la reserva de stock
+	if _, err := orderCollection.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: order.Order_ID}}); err != nil {
+		log.Printf("could not roll back order %s: %v", order.Order_ID.Hex(), err)
+	}
+	releaseCartStock(ctx, prodCollection, reserved)
+	return ErrCantBuyCartItem
+}
+
+// InstantBuyer genera una orden con un único producto, sin pasar por el carrito, y descuenta
+// una unidad de stock. Igual que el checkout corre en una transacción cuando el servidor lo
+// permite; si no, la reserva de stock se devuelve a mano cuando no se puede guardar la orden.
+// La dirección de envío se elige igual que en BuyItemFromCart.
+func InstantBuyer(ctx context.Context, prodCollection, userCollection, orderCollection *mongo.Collection, productID primitive.ObjectID, userID string, addressID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	err = runInTransaction(ctx, userCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		return instantBuy(sessCtx, prodCollection, userCollection, orderCollection, productID, id, addressID, false)
+	})
+	if errors.Is(err, errTransactionsNotSupported) {
+		return instantBuy(ctx, prodCollection, userCollection, orderCollection, productID, id, addressID, true)
+	}
+	return err
+}
+
+// instantBuy hace la compra directa. Con compensate en true (sin transacción) devuelve
+// el stock reservado si la orden no se pudo guardar.
+func instantBuy(ctx context.Context, prodCollection, userCollection, orderCollection *mongo.Collection, productID, id, addressID primitive.ObjectID, compensate bool) error {
+	// El comprador tiene que existir antes de tocar el stock; de él solo necesitamos las direcciones
+	var user struct {
+		Address_Details []models.Address `bson:"address"`
+	}
+	findOptions := options.FindOne().SetProjection(bson.D{primitive.E{Key: "address", Value: 1}})
+	err := userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}, findOptions).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserIdIsNotValid
+	}
+	if err != nil {
+		return checkoutError(err)
+	}
+
+	product, err := findProduct(ctx, prodCollection, productID)
+	if err != nil {
+		return err
+	}
+
+	product.Quantity = 1
+	order, err := newOrder(id, []models.ProductUser{product}, user.Address_Details, addressID)
+	if err != nil {
+		return err
+	}
+
+	reserved, err := reserveCartStock(ctx, prodCollection, []models.ProductUser{product})
+	if err != nil {
+		return checkoutError(err)
+	}
+
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		if compensate {
+			releaseCartStock(ctx, prodCollection, reserved)
+		}
+		return checkoutError(err)
+	}
+	return nil
+}
+
+// newOrder arma una orden nueva del usuario con las líneas indicadas y su total.
+// Toda orden nace pendiente. Si las líneas tienen monedas distintas devuelve models.ErrCurrencyMismatch,
+// y si no se puede elegir una dirección de envío, el error de orderAddresses.
+func newOrder(userID primitive.ObjectID, cart []models.ProductUser, addresses []models.Address, addressID primitive.ObjectID) (models.Order, error) {
+	total, err := models.CartTotal(cart)
+	if err != nil {
+		return models.Order{}, err
+	}
+	shipping, billing, err := orderAddresses(addresses, addressID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	now := time.Now()
+	return models.Order{
+		Order_ID:         primitive.NewObjectID(),
+		User_ID:          userID.Hex(),
+		Ordered_at:       now,
+		Order_Cart:       cart,
+		Price:            total,
+		Payment_Method:   models.Payment{COD: true},
+		Shipping_Address: shipping,
+		Billing_Address:  billing,
+		Status:           models.ORDER_STATUS_PENDING,
+		Status_History:   []models.StatusChange{{Status: models.ORDER_STATUS_PENDING, At: now, By: userID.Hex()}},
+	}, nil
+}
+
+// checkoutError deja pasar los errores que el handler o la transacción necesitan ver
+// (stock insuficiente y los de isTransactionError) y reemplaza el resto por ErrCantBuyCartItem.
+func checkoutError(err error) error {
+	if errors.Is(err, ErrInsufficientStock) || isTransactionError(err) {
+		return err
+	}
+	log.Println(err)
+	return ErrCantBuyCartItem
+}
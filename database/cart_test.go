@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRemoveCartItem(t *testing.T) {
+	db := standaloneDatabase(t)
+	f := newCheckoutFixture(t, db)
+
+	tests := []struct {
+		name      string
+		productID primitive.ObjectID
+		userID    string
+		want      error
+	}{
+		{"product not in the cart", primitive.NewObjectID(), f.userID.Hex(), ErrItemNotInCart},
+		{"unknown user", f.productID, primitive.NewObjectID().Hex(), ErrUserIdIsNotValid},
+		{"invalid user id", f.productID, "not-an-id", ErrUserIdIsNotValid},
+		{"product in the cart", f.productID, f.userID.Hex(), nil},
+		{"product already removed", f.productID, f.userID.Hex(), ErrItemNotInCart},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RemoveCartItem(context.Background(), f.products, f.users, tt.productID, tt.userID)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("RemoveCartItem() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	var user models.User
+	if err := f.users.FindOne(context.Background(), bson.D{primitive.E{Key: "_id", Value: f.userID}}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if len(user.UserCart) != 0 {
+		t.Errorf("cart has %d line(s), want 0", len(user.UserCart))
+	}
+}
+
+func TestSetCartItemQuantityZero(t *testing.T) {
+	db := standaloneDatabase(t)
+	f := newCheckoutFixture(t, db)
+
+	err := SetCartItemQuantity(context.Background(), f.users, primitive.NewObjectID(), f.userID.Hex(), 0)
+	if !errors.Is(err, ErrItemNotInCart) {
+		t.Fatalf("SetCartItemQuantity() for a product not in the cart error = %v, want %v", err, ErrItemNotInCart)
+	}
+	if err := SetCartItemQuantity(context.Background(), f.users, f.productID, f.userID.Hex(), 0); err != nil {
+		t.Fatalf("SetCartItemQuantity() error = %v", err)
+	}
+}
+
+func TestAddProductToCartAtLimit(t *testing.T) {
+	db := standaloneDatabase(t)
+	f := newCheckoutFixture(t, db)
+	ctx := context.Background()
+
+	if err := SetCartItemQuantity(ctx, f.users, f.productID, f.userID.Hex(), MaxCartQuantity-1); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name         string
+		want         error
+		wantQuantity int
+	}{
+		{"last unit below the limit", nil, MaxCartQuantity},
+		{"line already at the limit", ErrQuantityOutOfRange, MaxCartQuantity},
+	}
+	for _, step := range steps {
+		err := AddProductToCart(ctx, f.products, f.users, f.productID, f.userID.Hex())
+		if !errors.Is(err, step.want) {
+			t.Fatalf("%s: AddProductToCart() error = %v, want %v", step.name, err, step.want)
+		}
+
+		var user models.User
+		if err := f.users.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: f.userID}}).Decode(&user); err != nil {
+			t.Fatal(err)
+		}
+		if len(user.UserCart) != 1 || user.UserCart[0].Quantity != step.wantQuantity {
+			t.Errorf("%s: cart = %+v, want one line with %d units", step.name, user.UserCart, step.wantQuantity)
+		}
+	}
+}
+
+func TestNewOrderCurrencyMismatch(t *testing.T) {
+	name := "Mate"
+	cart := []models.ProductUser{
+		{Product_ID: primitive.NewObjectID(), Product_Name: &name, Price: models.NewMoney(1500, "USD"), Quantity: 1},
+		{Product_ID: primitive.NewObjectID(), Product_Name: &name, Price: models.NewMoney(90000, "ARS"), Quantity: 1},
+	}
+	_, err := newOrder(primitive.NewObjectID(), cart, nil, primitive.NilObjectID)
+	if !errors.Is(err, models.ErrCurrencyMismatch) {
+		t.Errorf("newOrder() error = %v, want %v", err, models.ErrCurrencyMismatch)
+	}
+}
@@ -4,15 +4,25 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func DBSet() *mongo.Client {
+	// La URI se puede definir con MONGODB_URI. El checkout usa transacciones, que requieren
+	// un replica set (por ejemplo "mongodb://localhost:27017/?replicaSet=rs0"); contra un
+	// mongod standalone funciona igual, con updates atómicos sobre un solo documento.
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+
 	// Crear una nueva instancia del cliente de MongoDB con la URI de conexión proporcionada
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -27,11 +37,13 @@ func DBSet() *mongo.Client {
 		log.Fatal(err)
 	}
 
-	// Verificar si el cliente puede realizar un ping al servidor de MongoDB para asegurar la conectividad
-	err = client.Ping(context.TODO(), nil)
+	// Verificar si el cliente puede realizar un ping al servidor de MongoDB para asegurar la conectividad.
+	// Si no responde devolvemos el cliente igual: el driver se reconecta solo cuando el servidor
+	// aparece y cada operación devuelve su propio error. Así importar el paquete nunca entra en pánico.
+	err = client.Ping(ctx, nil)
 	if err != nil {
-		log.Println("Error al conectar con MongoDB")
-		return nil
+		log.Println("Error al conectar con MongoDB:", err)
+		return client
 	}
 	fmt.Println("Conexión exitosa a MongoDB")
 	return client // Devolver el cliente de MongoDB conectado
@@ -50,3 +62,46 @@ func ProductData(client *mongo.Client, collectionName string) *mongo.Collection
 	var productCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
 	return productCollection // Devuelve la colección de productos obtenida
 }
+
+func RevokedTokenData(client *mongo.Client, collectionName string) *mongo.Collection {
+	// Obtiene la colección de tokens revocados (lista de revocación del lado del servidor)
+	var revokedCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return revokedCollection
+}
+
+func OrderData(client *mongo.Client, collectionName string) *mongo.Collection {
+	// Obtiene la colección de órdenes, separada de los usuarios para que su documento no crezca sin límite
+	var orderCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return orderCollection
+}
+
+func StockAdjustmentData(client *mongo.Client, collectionName string) *mongo.Collection {
+	// Obtiene la colección con la auditoría de ajustes de stock
+	var adjustmentCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return adjustmentCollection
+}
+
+// EnsureProductIndexes crea el índice de texto sobre el nombre del producto que usa la búsqueda
+// con ?mode=text. Es idempotente, se llama al iniciar la aplicación.
+func EnsureProductIndexes(prodCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := prodCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_name", Value: "text"}},
+		Options: options.Index().SetName("product_name_text"),
+	})
+	return err
+}
+
+// EnsureOrderIndexes crea el índice por usuario y fecha que usa el historial de órdenes.
+func EnsureOrderIndexes(orderCollection *mongo.Collection) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "ordered_at", Value: -1}},
+		Options: options.Index().SetName("user_id_ordered_at"),
+	})
+	return err
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Errores de las operaciones sobre órdenes
+var (
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrInvalidOrderStatus     = errors.New("invalid order status")
+	ErrIllegalOrderTransition = errors.New("illegal order status transition")
+	ErrCantUpdateOrder        = errors.New("cannot update the order")
+	ErrCantGetOrder           = errors.New("cannot get the order")
+	ErrUseCancelOrder         = errors.New("orders are cancelled with CancelOrder")
+)
+
+// IllegalTransitionError indica desde qué estado se intentó un cambio no permitido.
+// Se compara con errors.Is(err, ErrIllegalOrderTransition).
+type IllegalTransitionError struct {
+	From models.OrderStatus
+	To   models.OrderStatus
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("cannot move order from %s to %s", e.From, e.To)
+}
+
+func (e *IllegalTransitionError) Is(target error) bool {
+	return target == ErrIllegalOrderTransition
+}
+
+// TransitionOrder cambia el estado de la orden a "to" y agrega el cambio al historial.
+// Las transiciones permitidas están en models (ver models.OrderStatus.CanTransitionTo) y el
+// update es condicional sobre el estado actual, así dos cambios simultáneos no se pisan.
+// Pasar una orden a cancelled se hace con CancelOrder, que además devuelve el stock.
+func TransitionOrder(ctx context.Context, orderCollection *mongo.Collection, orderID primitive.ObjectID, to models.OrderStatus, by string, reason string) (models.Order, error) {
+	if to == models.ORDER_STATUS_CANCELLED {
+		return models.Order{}, ErrUseCancelOrder
+	}
+	return transitionOrder(ctx, orderCollection, orderID, "", to, by, reason)
+}
+
+// transitionOrder hace el cambio de estado. Si ownerID no está vacío, la orden además
+// tiene que pertenecer a ese usuario (si no, se responde como inexistente).
+func transitionOrder(ctx context.Context, orderCollection *mongo.Collection, orderID primitive.ObjectID, ownerID string, to models.OrderStatus, by string, reason string) (models.Order, error) {
+	var order models.Order
+	if !to.IsValid() {
+		return order, ErrInvalidOrderStatus
+	}
+
+	change := models.StatusChange{Status: to, At: time.Now(), By: by, Reason: reason}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{primitive.E{Key: "status", Value: to}}},
+		{Key: "$push", Value: bson.D{primitive.E{Key: "status_history", Value: change}}},
+	}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	filter := statusFilter(orderID, models.StatusesBefore(to))
+	idFilter := bson.D{primitive.E{Key: "_id", Value: orderID}}
+	if ownerID != "" {
+		filter = append(filter, primitive.E{Key: "user_id", Value: ownerID})
+		idFilter = append(idFilter, primitive.E{Key: "user_id", Value: ownerID})
+	}
+	err := orderCollection.FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&order)
+	if err == nil {
+		return order, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		if isTransactionError(err) {
+			return order, err
+		}
+		log.Println(err)
+		return order, ErrCantUpdateOrder
+	}
+
+	// Sin match: o la orden no existe o su estado actual no permite el cambio
+	var current models.Order
+	err = orderCollection.FindOne(ctx, idFilter).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrOrderNotFound
+	}
+	if isTransactionError(err) {
+		return order, err
+	}
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantUpdateOrder
+	}
+	return order, &IllegalTransitionError{From: current.CurrentStatus(), To: to}
+}
+
+// CancelOrder cancela la orden, registra el motivo y devuelve al stock las unidades de cada línea.
+// Solo se puede cancelar mientras la orden está pending o paid (todavía no enviada). Con ownerID
+// vacío se cancela la orden de cualquier usuario (uso de administradores).
+// El cambio de estado y la devolución del stock corren en una transacción; sin transacciones
+// primero se hace el cambio de estado, que es condicional y atómico, y después se devuelve el stock.
+func CancelOrder(ctx context.Context, prodCollection, orderCollection *mongo.Collection, orderID primitive.ObjectID, ownerID string, by string, reason string) (models.Order, error) {
+	var order models.Order
+	err := runInTransaction(ctx, orderCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		order, err = cancelOrder(sessCtx, prodCollection, orderCollection, orderID, ownerID, by, reason)
+		return err
+	})
+	if errors.Is(err, errTransactionsNotSupported) {
+		return cancelOrder(ctx, prodCollection, orderCollection, orderID, ownerID, by, reason)
+	}
+	return order, err
+}
+
+func cancelOrder(ctx context.Context, prodCollection, orderCollection *mongo.Collection, orderID primitive.ObjectID, ownerID string, by string, reason string) (models.Order, error) {
+	order, err := transitionOrder(ctx, orderCollection, orderID, ownerID, models.ORDER_STATUS_CANCELLED, by, reason)
+	if err != nil {
+		return order, err
+	}
+
+	for _, item := range order.Order_Cart {
+		if err := releaseStock(ctx, prodCollection, item.Product_ID, item.Units()); err != nil {
+			if isTransactionError(err) {
+				return order, err
+			}
+			log.Printf("could not restore %d unit(s) of product %s for order %s: %v", item.Units(), item.Product_ID.Hex(), orderID.Hex(), err)
+			return order, ErrCantUpdateOrder
+		}
+	}
+	return order, nil
+}
+
+// statusFilter arma el filtro de la orden cuando su estado es uno de from. Las órdenes
+// viejas sin estado cuentan como models.LegacyOrderStatus (ver models.Order.CurrentStatus).
+func statusFilter(orderID primitive.ObjectID, from []models.OrderStatus) bson.D {
+	statuses := bson.A{}
+	includesLegacy := false
+	for _, status := range from {
+		statuses = append(statuses, status)
+		if status == models.LegacyOrderStatus {
+			includesLegacy = true
+		}
+	}
+
+	statusCondition := bson.A{bson.D{primitive.E{Key: "status", Value: bson.D{primitive.E{Key: "$in", Value: statuses}}}}}
+	if includesLegacy {
+		statusCondition = append(statusCondition, bson.D{primitive.E{Key: "status", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}})
+	}
+	return bson.D{
+		primitive.E{Key: "_id", Value: orderID},
+		primitive.E{Key: "$or", Value: statusCondition},
+	}
+}
+
+// OrderFilter son los filtros opcionales del historial de órdenes. From es inclusivo y To exclusivo.
+type OrderFilter struct {
+	Status models.OrderStatus
+	From   time.Time
+	To     time.Time
+}
+
+// UserOrders busca las órdenes del usuario en la colección Orders, de la más nueva a la más vieja.
+// Reemplaza al viejo array "orders" que vivía dentro del documento del usuario.
+func UserOrders(ctx context.Context, orderCollection *mongo.Collection, userID string, orderFilter OrderFilter, findOptions *options.FindOptions) ([]models.Order, int64, error) {
+	filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
+	if orderFilter.Status != "" {
+		if orderFilter.Status == models.LegacyOrderStatus {
+			// Las órdenes viejas sin estado también cuentan
+			filter = append(filter, primitive.E{Key: "status", Value: bson.D{primitive.E{Key: "$in", Value: bson.A{orderFilter.Status, nil}}}})
+		} else {
+			filter = append(filter, primitive.E{Key: "status", Value: orderFilter.Status})
+		}
+	}
+	orderedAt := bson.D{}
+	if !orderFilter.From.IsZero() {
+		orderedAt = append(orderedAt, primitive.E{Key: "$gte", Value: orderFilter.From})
+	}
+	if !orderFilter.To.IsZero() {
+		orderedAt = append(orderedAt, primitive.E{Key: "$lt", Value: orderFilter.To})
+	}
+	if len(orderedAt) > 0 {
+		filter = append(filter, primitive.E{Key: "ordered_at", Value: orderedAt})
+	}
+
+	total, err := orderCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	findOptions.SetSort(bson.D{primitive.E{Key: "ordered_at", Value: -1}, primitive.E{Key: "_id", Value: -1}})
+	cursor, err := orderCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	orders := make([]models.Order, 0)
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, 0, err
+	}
+	return orders, total, nil
+}
+
+// UserOrder busca una orden del usuario. Si la orden existe pero es de otro usuario
+// devuelve ErrOrderNotFound, así no se puede averiguar qué órdenes existen.
+func UserOrder(ctx context.Context, orderCollection *mongo.Collection, userID string, orderID primitive.ObjectID) (models.Order, error) {
+	var order models.Order
+	filter := bson.D{primitive.E{Key: "_id", Value: orderID}, primitive.E{Key: "user_id", Value: userID}}
+	err := orderCollection.FindOne(ctx, filter).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return order, ErrCantGetOrder
+	}
+	return order, nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matchesStatus evalúa el filtro de statusFilter contra una orden guardada con ese estado.
+// Un estado vacío es una orden vieja, guardada sin el campo status.
+func matchesStatus(t *testing.T, filter bson.D, orderID primitive.ObjectID, status models.OrderStatus) bool {
+	t.Helper()
+	idOK, statusOK := false, false
+	for _, e := range filter {
+		switch e.Key {
+		case "_id":
+			idOK = e.Value == orderID
+		case "$or":
+			for _, condition := range e.Value.(bson.A) {
+				operator := condition.(bson.D)[0].Value.(bson.D)[0]
+				switch operator.Key {
+				case "$in":
+					for _, candidate := range operator.Value.(bson.A) {
+						statusOK = statusOK || (status != "" && candidate == status)
+					}
+				case "$exists":
+					statusOK = statusOK || (status == "" && operator.Value == false)
+				default:
+					t.Fatalf("unexpected operator %s", operator.Key)
+				}
+			}
+		default:
+			t.Fatalf("unexpected key %s in filter", e.Key)
+		}
+	}
+	return idOK && statusOK
+}
+
+func TestStatusFilter(t *testing.T) {
+	statuses := []models.OrderStatus{
+		models.ORDER_STATUS_PENDING,
+		models.ORDER_STATUS_PAID,
+		models.ORDER_STATUS_SHIPPED,
+		models.ORDER_STATUS_DELIVERED,
+		models.ORDER_STATUS_CANCELLED,
+		models.ORDER_STATUS_REFUNDED,
+	}
+	orderID := primitive.NewObjectID()
+
+	// "" es una orden sin status guardado, que se considera models.LegacyOrderStatus
+	for _, from := range append([]models.OrderStatus{""}, statuses...) {
+		for _, to := range statuses {
+			current := models.Order{Status: from}.CurrentStatus()
+			want := current.CanTransitionTo(to)
+			t.Run(string(from)+"->"+string(to), func(t *testing.T) {
+				filter := statusFilter(orderID, models.StatusesBefore(to))
+				if got := matchesStatus(t, filter, orderID, from); got != want {
+					t.Errorf("filter matches = %v, want %v", got, want)
+				}
+				if matchesStatus(t, filter, primitive.NewObjectID(), from) {
+					t.Error("filter matches another order")
+				}
+			})
+		}
+	}
+}
+
+func TestStatusFilterLegacyOrderCannotBeCancelled(t *testing.T) {
+	orderID := primitive.NewObjectID()
+	filter := statusFilter(orderID, models.StatusesBefore(models.ORDER_STATUS_CANCELLED))
+	if matchesStatus(t, filter, orderID, "") {
+		t.Error("an order without a stored status can be cancelled")
+	}
+}
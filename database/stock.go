@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInsufficientStock se compara con errors.Is; el error concreto es *InsufficientStockError,
+// que además lista los productos sin stock suficiente.
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrCantAdjustStock   = errors.New("cannot adjust the stock")
+)
+
+// InsufficientStockError indica qué productos no tienen stock para la cantidad pedida.
+type InsufficientStockError struct {
+	ProductIDs []primitive.ObjectID
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %d product(s)", len(e.ProductIDs))
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// ErrorDetails son los datos que acompañan la respuesta de error: los productos sin stock.
+func (e *InsufficientStockError) ErrorDetails() interface{} {
+	return map[string]interface{}{"product_ids": e.ProductIDs}
+}
+
+// reserveStock descuenta qty unidades del producto solo si hay stock suficiente.
+// La condición stock >= qty y el $inc van en el mismo update, así dos compras
+// simultáneas nunca pueden dejar el stock en negativo.
+// Un producto sin campo stock (creado antes del control de stock) nunca coincide y se trata
+// como agotado; cmd/backfill-stock les carga el campo.
+func reserveStock(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, qty int) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: productID},
+		primitive.E{Key: "stock", Value: bson.D{primitive.E{Key: "$gte", Value: qty}}},
+	}
+	update := bson.D{{Key: "$inc", Value: bson.D{primitive.E{Key: "stock", Value: -qty}}}}
+	result, err := prodCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// releaseStock devuelve unidades al stock de un producto.
+func releaseStock(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, qty int) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	update := bson.D{{Key: "$inc", Value: bson.D{primitive.E{Key: "stock", Value: qty}}}}
+	_, err := prodCollection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// reserveCartStock reserva el stock de todas las líneas. Si alguna no alcanza devuelve un
+// *InsufficientStockError con todos los productos que fallaron. Las reservas que sí se hicieron
+// se devuelven en reserved para que quien llama las libere (fuera de una transacción).
+func reserveCartStock(ctx context.Context, prodCollection *mongo.Collection, cart []models.ProductUser) (reserved []models.ProductUser, err error) {
+	var missing []primitive.ObjectID
+	for _, item := range cart {
+		ok, err := reserveStock(ctx, prodCollection, item.Product_ID, item.Units())
+		if err != nil {
+			return reserved, err
+		}
+		if !ok {
+			missing = append(missing, item.Product_ID)
+			continue
+		}
+		reserved = append(reserved, item)
+	}
+	if len(missing) > 0 {
+		return reserved, &InsufficientStockError{ProductIDs: missing}
+	}
+	return reserved, nil
+}
+
+// releaseCartStock deshace las reservas hechas fuera de una transacción.
+func releaseCartStock(ctx context.Context, prodCollection *mongo.Collection, reserved []models.ProductUser) {
+	for _, item := range reserved {
+		if err := releaseStock(ctx, prodCollection, item.Product_ID, item.Units()); err != nil {
+			log.Printf("could not release %d unit(s) of product %s: %v", item.Units(), item.Product_ID.Hex(), err)
+		}
+	}
+}
+
+// AdjustStock suma delta (positivo o negativo) al stock del producto y registra el ajuste
+// en la colección de auditoría. Un ajuste que dejaría el stock en negativo se rechaza.
+func AdjustStock(ctx context.Context, prodCollection, adjustmentCollection *mongo.Collection, productID primitive.ObjectID, delta int64, reason string, adminID string) (models.StockAdjustment, error) {
+	var adjustment models.StockAdjustment
+	err := runInTransaction(ctx, prodCollection.Database().Client(), func(sessCtx mongo.SessionContext) error {
+		var err error
+		adjustment, err = adjustStock(sessCtx, prodCollection, adjustmentCollection, productID, delta, reason, adminID)
+		return err
+	})
+	if errors.Is(err, errTransactionsNotSupported) {
+		return adjustStock(ctx, prodCollection, adjustmentCollection, productID, delta, reason, adminID)
+	}
+	return adjustment, err
+}
+
+func adjustStock(ctx context.Context, prodCollection, adjustmentCollection *mongo.Collection, productID primitive.ObjectID, delta int64, reason string, adminID string) (models.StockAdjustment, error) {
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	if delta < 0 {
+		filter = append(filter, primitive.E{Key: "stock", Value: bson.D{primitive.E{Key: "$gte", Value: -delta}}})
+	}
+	update := bson.D{{Key: "$inc", Value: bson.D{primitive.E{Key: "stock", Value: delta}}}}
+
+	var product models.Products
+	err := prodCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Distinguimos producto inexistente de stock insuficiente
+		count, err := prodCollection.CountDocuments(ctx, bson.D{primitive.E{Key: "_id", Value: productID}})
+		if isTransactionError(err) {
+			return models.StockAdjustment{}, err
+		}
+		if err != nil {
+			log.Println(err)
+			return models.StockAdjustment{}, ErrCantAdjustStock
+		}
+		if count == 0 {
+			return models.StockAdjustment{}, ErrCantFindProduct
+		}
+		return models.StockAdjustment{}, &InsufficientStockError{ProductIDs: []primitive.ObjectID{productID}}
+	}
+	if err != nil {
+		if isTransactionError(err) {
+			return models.StockAdjustment{}, err
+		}
+		log.Println(err)
+		return models.StockAdjustment{}, ErrCantAdjustStock
+	}
+
+	adjustment := models.StockAdjustment{
+		ID:          primitive.NewObjectID(),
+		Product_ID:  productID,
+		Delta:       delta,
+		Stock_After: product.Stock,
+		Reason:      reason,
+		Admin_ID:    adminID,
+		Created_At:  time.Now(),
+	}
+	if _, err := adjustmentCollection.InsertOne(ctx, adjustment); err != nil {
+		if isTransactionError(err) {
+			return models.StockAdjustment{}, err
+		}
+		log.Println(err)
+		return models.StockAdjustment{}, ErrCantAdjustStock
+	}
+	return adjustment, nil
+}
+
+// StockAdjustments devuelve el historial de ajustes de un producto, del más nuevo al más viejo.
+func StockAdjustments(ctx context.Context, adjustmentCollection *mongo.Collection, productID primitive.ObjectID, findOptions *options.FindOptions) ([]models.StockAdjustment, int64, error) {
+	filter := bson.D{primitive.E{Key: "product_id", Value: productID}}
+	total, err := adjustmentCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	findOptions.SetSort(bson.D{primitive.E{Key: "created_at", Value: -1}, primitive.E{Key: "_id", Value: -1}})
+	cursor, err := adjustmentCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	adjustments := make([]models.StockAdjustment, 0)
+	if err := cursor.All(ctx, &adjustments); err != nil {
+		return nil, 0, err
+	}
+	return adjustments, total, nil
+}
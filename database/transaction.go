@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errTransactionsNotSupported indica que el servidor no acepta transacciones
+// (un mongod standalone, sin replica set). Quien lo recibe usa su camino alternativo.
+var errTransactionsNotSupported = errors.New("transactions are not supported by this deployment")
+
+// transactionsUnsupported recuerda que el servidor ya rechazó una transacción,
+// así no volvemos a intentarlo en cada checkout.
+var transactionsUnsupported atomic.Bool
+
+// transactionsChecked indica que ya le preguntamos al servidor si es un replica set (ver supportsTransactions).
+var transactionsChecked atomic.Bool
+
+// transientTransactionLabel es la etiqueta que mongo agrega a los errores que se pueden reintentar
+const transientTransactionLabel = "TransientTransactionError"
+
+// illegalOperationCode es el código que devuelve mongo al usar transacciones fuera de un replica set
+const illegalOperationCode = 20
+
+// runInTransaction ejecuta fn dentro de una transacción. WithTransaction reintenta fn ante
+// errores transitorios, por eso fn no debe tener efectos fuera de la base de datos.
+// Dentro de fn, los errores de mongo para los que isTransactionError da true se devuelven
+// tal cual: si se reemplazan por un error propio no hay reintento ni camino alternativo.
+func runInTransaction(ctx context.Context, client *mongo.Client, fn func(sessCtx mongo.SessionContext) error) error {
+	if !supportsTransactions(ctx, client) {
+		return errTransactionsNotSupported
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	if isTransactionNotSupported(err) {
+		log.Println("mongo transactions are not available (a replica set is required), using single-document updates")
+		transactionsUnsupported.Store(true)
+		return errTransactionsNotSupported
+	}
+	return err
+}
+
+// supportsTransactions le pregunta al servidor una sola vez, con el comando hello, si es parte
+// de un replica set o un mongos. Si no se puede preguntar (por ejemplo un servidor anterior a
+// hello) se intenta la transacción igual y el error del servidor decide.
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	if transactionsUnsupported.Load() {
+		return false
+	}
+	if transactionsChecked.Load() {
+		return true
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Println(err)
+		return true
+	}
+	transactionsChecked.Store(true)
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		log.Println("mongo transactions are not available (a replica set is required), using single-document updates")
+		transactionsUnsupported.Store(true)
+		return false
+	}
+	return true
+}
+
+func isTransactionNotSupported(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(illegalOperationCode)
+}
+
+// isTransientError indica si mongo marcó el error como transitorio, es decir que WithTransaction
+// puede reintentar la transacción.
+func isTransientError(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorLabel(transientTransactionLabel)
+}
+
+// isTransactionError indica si el error le pertenece a runInTransaction: uno transitorio, que
+// WithTransaction reintenta, o el rechazo de un servidor sin transacciones, que activa el camino
+// alternativo. Dentro de una transacción esos errores se devuelven sin reemplazarlos.
+func isTransactionError(err error) bool {
+	return isTransientError(err) || isTransactionNotSupported(err)
+}
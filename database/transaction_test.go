@@ -0,0 +1,367 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Estos tests corren contra un mongod standalone (sin replica set), que es donde las operaciones
+// tienen que caer en su camino sin transacciones. Por ejemplo:
+//
+//	docker run -d --rm -p 27018:27017 mongo:7
+//	MONGODB_STANDALONE_URI=mongodb://localhost:27018 go test ./database/
+//
+// Sin MONGODB_STANDALONE_URI se saltean. Cada test usa una base de datos propia que se borra al final.
+
+func standaloneDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGODB_STANDALONE_URI")
+	if uri == "" {
+		t.Skip("MONGODB_STANDALONE_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.SetName != "" {
+		t.Fatalf("MONGODB_STANDALONE_URI points to replica set %q, these tests need a standalone server", hello.SetName)
+	}
+
+	db := client.Database("ecommerce_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() { db.Drop(context.Background()) })
+
+	// Cada test empieza sin saber si el servidor soporta transacciones
+	resetTransactionSupport()
+	t.Cleanup(resetTransactionSupport)
+	return db
+}
+
+func resetTransactionSupport() {
+	transactionsUnsupported.Store(false)
+	transactionsChecked.Store(false)
+}
+
+// skipTransactionCheck hace que runInTransaction crea que el servidor soporta transacciones,
+// así la operación llega a la sesión y tiene que reaccionar al error del servidor (código 20).
+func skipTransactionCheck() {
+	transactionsChecked.Store(true)
+}
+
+type checkoutFixture struct {
+	products, users, orders, adjustments *mongo.Collection
+	productID                            primitive.ObjectID
+	userID                               primitive.ObjectID
+}
+
+// newCheckoutFixture guarda un producto con 5 unidades de stock y un usuario con 2 de ellas
+// en el carrito y una única dirección.
+func newCheckoutFixture(t *testing.T, db *mongo.Database) checkoutFixture {
+	t.Helper()
+	ctx := context.Background()
+	f := checkoutFixture{
+		products:    db.Collection("Products"),
+		users:       db.Collection("Users"),
+		orders:      db.Collection("Orders"),
+		adjustments: db.Collection("StockAdjustments"),
+		productID:   primitive.NewObjectID(),
+		userID:      primitive.NewObjectID(),
+	}
+
+	name := "Mate"
+	price := models.NewMoney(1500, "USD")
+	stock := int64(5)
+	_, err := f.products.InsertOne(ctx, models.Products{Product_ID: f.productID, Product_Name: &name, Price: &price, Stock: &stock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	house, street, city, pincode, country := "12", "Rivadavia", "Buenos Aires", "C1002AAR", "AR"
+	_, err = f.users.InsertOne(ctx, models.User{
+		ID:       f.userID,
+		User_ID:  f.userID.Hex(),
+		UserCart: []models.ProductUser{{Product_ID: f.productID, Product_Name: &name, Price: price, Quantity: 2}},
+		Address_Details: []models.Address{{
+			Address_id: primitive.NewObjectID(),
+			Label:      models.ADDRESS_LABEL_HOME,
+			House:      &house,
+			Street:     &street,
+			City:       &city,
+			Pincode:    &pincode,
+			Country:    &country,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f checkoutFixture) stock(t *testing.T) int64 {
+	t.Helper()
+	var product models.Products
+	if err := f.products.FindOne(context.Background(), bson.D{primitive.E{Key: "_id", Value: f.productID}}).Decode(&product); err != nil {
+		t.Fatal(err)
+	}
+	if product.Stock == nil {
+		t.Fatal("product has no stock")
+	}
+	return *product.Stock
+}
+
+func (f checkoutFixture) orderCount(t *testing.T) int64 {
+	t.Helper()
+	count, err := f.orders.CountDocuments(context.Background(), bson.D{primitive.E{Key: "user_id", Value: f.userID.Hex()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSupportsTransactionsOnStandalone(t *testing.T) {
+	db := standaloneDatabase(t)
+
+	if supportsTransactions(context.Background(), db.Client()) {
+		t.Fatal("supportsTransactions() = true on a standalone server")
+	}
+	if !transactionsUnsupported.Load() {
+		t.Fatal("the result was not remembered")
+	}
+}
+
+func (f checkoutFixture) order(t *testing.T) models.Order {
+	t.Helper()
+	var order models.Order
+	if err := f.orders.FindOne(context.Background(), bson.D{primitive.E{Key: "user_id", Value: f.userID.Hex()}}).Decode(&order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// standaloneOperations son las operaciones que usan runInTransaction. Cada una tiene que terminar
+// igual por su camino sin transacciones, tanto si el servidor se detecta antes como si recién
+// rechaza la transacción (código 20) cuando ya está adentro.
+var standaloneOperations = []struct {
+	name string
+	// setup prepara lo que la operación necesita; corre antes de elegir cómo falla la transacción
+	setup func(t *testing.T, f checkoutFixture)
+	run   func(t *testing.T, f checkoutFixture) error
+	check func(t *testing.T, f checkoutFixture)
+}{
+	{
+		name: "BuyItemFromCart",
+		run: func(t *testing.T, f checkoutFixture) error {
+			return BuyItemFromCart(context.Background(), f.products, f.users, f.orders, f.userID.Hex(), primitive.NilObjectID)
+		},
+		check: func(t *testing.T, f checkoutFixture) {
+			if got := f.stock(t); got != 3 {
+				t.Errorf("stock = %d, want 3", got)
+			}
+			if got := f.orderCount(t); got != 1 {
+				t.Errorf("orders = %d, want 1", got)
+			}
+			var user models.User
+			if err := f.users.FindOne(context.Background(), bson.D{primitive.E{Key: "_id", Value: f.userID}}).Decode(&user); err != nil {
+				t.Fatal(err)
+			}
+			if len(user.UserCart) != 0 {
+				t.Errorf("cart has %d line(s) after checkout, want 0", len(user.UserCart))
+			}
+		},
+	},
+	{
+		name: "InstantBuyer",
+		run: func(t *testing.T, f checkoutFixture) error {
+			return InstantBuyer(context.Background(), f.products, f.users, f.orders, f.productID, f.userID.Hex(), primitive.NilObjectID)
+		},
+		check: func(t *testing.T, f checkoutFixture) {
+			if got := f.stock(t); got != 4 {
+				t.Errorf("stock = %d, want 4", got)
+			}
+			if got := f.orderCount(t); got != 1 {
+				t.Errorf("orders = %d, want 1", got)
+			}
+		},
+	},
+	{
+		name: "AdjustStock",
+		run: func(t *testing.T, f checkoutFixture) error {
+			adjustment, err := AdjustStock(context.Background(), f.products, f.adjustments, f.productID, -2, "broken in transit", "admin")
+			if err == nil && (adjustment.Stock_After == nil || *adjustment.Stock_After != 3) {
+				t.Errorf("Stock_After = %v, want 3", adjustment.Stock_After)
+			}
+			return err
+		},
+		check: func(t *testing.T, f checkoutFixture) {
+			if got := f.stock(t); got != 3 {
+				t.Errorf("stock = %d, want 3", got)
+			}
+			count, err := f.adjustments.CountDocuments(context.Background(), bson.D{primitive.E{Key: "product_id", Value: f.productID}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Errorf("audit entries = %d, want 1", count)
+			}
+		},
+	},
+	{
+		name: "CancelOrder",
+		setup: func(t *testing.T, f checkoutFixture) {
+			if err := BuyItemFromCart(context.Background(), f.products, f.users, f.orders, f.userID.Hex(), primitive.NilObjectID); err != nil {
+				t.Fatalf("BuyItemFromCart() error = %v", err)
+			}
+		},
+		run: func(t *testing.T, f checkoutFixture) error {
+			_, err := CancelOrder(context.Background(), f.products, f.orders, f.order(t).Order_ID, f.userID.Hex(), f.userID.Hex(), "changed my mind")
+			return err
+		},
+		check: func(t *testing.T, f checkoutFixture) {
+			if got := f.order(t).Status; got != models.ORDER_STATUS_CANCELLED {
+				t.Errorf("status = %s, want %s", got, models.ORDER_STATUS_CANCELLED)
+			}
+			if got := f.stock(t); got != 5 {
+				t.Errorf("stock = %d, want 5", got)
+			}
+		},
+	},
+}
+
+func TestOperationsOnStandalone(t *testing.T) {
+	modes := []struct {
+		name    string
+		prepare func()
+	}{
+		{"detected before the transaction", func() {}},
+		{"rejected inside the transaction", skipTransactionCheck},
+	}
+	for _, op := range standaloneOperations {
+		for _, mode := range modes {
+			t.Run(op.name+"/"+mode.name, func(t *testing.T) {
+				db := standaloneDatabase(t)
+				f := newCheckoutFixture(t, db)
+				if op.setup != nil {
+					op.setup(t, f)
+				}
+				resetTransactionSupport()
+				mode.prepare()
+
+				if err := op.run(t, f); err != nil {
+					t.Fatalf("%s() error = %v", op.name, err)
+				}
+				op.check(t, f)
+				if !transactionsUnsupported.Load() {
+					t.Error("the missing transaction support was not remembered")
+				}
+			})
+		}
+	}
+}
+
+// Los tests que siguen no necesitan servidor.
+
+func TestIsTransactionError(t *testing.T) {
+	notSupported := mongo.CommandError{Code: illegalOperationCode, Message: "Transaction numbers are only allowed on a replica set member or mongos"}
+	transient := mongo.CommandError{Code: 112, Message: "WriteConflict", Labels: []string{transientTransactionLabel}}
+
+	tests := []struct {
+		name             string
+		err              error
+		wantNotSupported bool
+		wantTransient    bool
+	}{
+		{"illegal operation", notSupported, true, false},
+		{"wrapped illegal operation", fmt.Errorf("find product: %w", notSupported), true, false},
+		{"illegal operation in a write", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: illegalOperationCode}}}, true, false},
+		{"transient", transient, false, true},
+		{"transient write", mongo.WriteException{Labels: []string{transientTransactionLabel}}, false, true},
+		{"other server error", mongo.CommandError{Code: 11000, Message: "duplicate key"}, false, false},
+		{"no documents", mongo.ErrNoDocuments, false, false},
+		{"plain error", errors.New("boom"), false, false},
+		{"nil", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransactionNotSupported(tt.err); got != tt.wantNotSupported {
+				t.Errorf("isTransactionNotSupported() = %v, want %v", got, tt.wantNotSupported)
+			}
+			if got := isTransientError(tt.err); got != tt.wantTransient {
+				t.Errorf("isTransientError() = %v, want %v", got, tt.wantTransient)
+			}
+			if got := isTransactionError(tt.err); got != (tt.wantNotSupported || tt.wantTransient) {
+				t.Errorf("isTransactionError() = %v", got)
+			}
+		})
+	}
+}
+
+func TestCheckoutError(t *testing.T) {
+	notSupported := mongo.CommandError{Code: illegalOperationCode}
+	transient := mongo.CommandError{Labels: []string{transientTransactionLabel}}
+	stockErr := &InsufficientStockError{ProductIDs: []primitive.ObjectID{primitive.NewObjectID()}}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"insufficient stock passes through", stockErr, stockErr},
+		{"illegal operation passes through", notSupported, notSupported},
+		{"transient passes through", transient, transient},
+		{"no documents", mongo.ErrNoDocuments, ErrCantBuyCartItem},
+		{"other server error", mongo.CommandError{Code: 11000}, ErrCantBuyCartItem},
+		{"plain error", errors.New("boom"), ErrCantBuyCartItem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkoutError(tt.err)
+			// mongo.CommandError no es comparable, errors.Is no sirve para él
+			if !errors.Is(got, tt.want) && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkoutError() = %v, want %v", got, tt.want)
+			}
+			if isTransactionError(tt.err) && !isTransactionError(got) {
+				t.Error("the transaction error was replaced")
+			}
+		})
+	}
+}
+
+func TestRunInTransactionRemembersUnsupported(t *testing.T) {
+	resetTransactionSupport()
+	t.Cleanup(resetTransactionSupport)
+	transactionsUnsupported.Store(true)
+
+	called := false
+	// Con el resultado recordado no se toca el cliente, por eso puede ser nil
+	err := runInTransaction(context.Background(), nil, func(mongo.SessionContext) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, errTransactionsNotSupported) {
+		t.Errorf("runInTransaction() error = %v, want %v", err, errTransactionsNotSupported)
+	}
+	if called {
+		t.Error("fn ran without a transaction")
+	}
+}
@@ -6,9 +6,8 @@ import (
 
 	"github.com/FrancoRutigliano/EcommerceGolang/controllers"
 	"github.com/FrancoRutigliano/EcommerceGolang/database"
-	"github.com/FrancoRutigliano/EcommerceGolang/middleware"
 	"github.com/FrancoRutigliano/EcommerceGolang/routes"
-	"github.com/gin-gonic/gin"
+	"github.com/FrancoRutigliano/EcommerceGolang/tokens"
 )
 
 func main() {
@@ -18,18 +17,29 @@ func main() {
 		port = "8000"
 	}
 
-	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
+	userCollection := database.UserData(database.Client, "Users")
+	tokenStore := tokens.NewStore(userCollection, database.RevokedTokenData(database.Client, "RevokedTokens"))
+	// Índice TTL de la lista de revocación: mongo borra solo las entradas vencidas
+	if err := tokenStore.EnsureRevocationIndexes(); err != nil {
+		log.Fatal(err)
+	}
+
+	productCollection := database.ProductData(database.Client, "Products")
+	// Índice de texto para la búsqueda de productos por relevancia
+	if err := database.EnsureProductIndexes(productCollection); err != nil {
+		log.Fatal(err)
+	}
+
+	orderCollection := database.OrderData(database.Client, "Orders")
+	if err := database.EnsureOrderIndexes(orderCollection); err != nil {
+		log.Fatal(err)
+	}
 
-	router := gin.New()
-	router.Use(gin.Logger())
+	stockAdjustmentCollection := database.StockAdjustmentData(database.Client, "StockAdjustments")
 
-	routes.UserRoutes(router)
-	router.Use(middleware.Authentication())
+	app := controllers.NewApplication(productCollection, userCollection, orderCollection, stockAdjustmentCollection, tokenStore)
 
-	router.GET("/addtocart", app.AddToCart())
-	router.GET("removeitem", app.RemoveItem())
-	router.GET("/cartcheckout", app.BuyFromCart())
-	router.GET("/instantbuy", app.InstantBuy())
+	router := routes.SetupRouter(app)
 
 	log.Fatal(router.Run(":" + port))
 }
@@ -1 +1,80 @@
 package middleware
+
+import (
+	"log"
+	"strings"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/apierrors"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	token "github.com/FrancoRutigliano/EcommerceGolang/tokens"
+	"github.com/gin-gonic/gin"
+)
+
+// RevocationChecker sabe si un token fue revocado con logout. En producción es *token.Store.
+type RevocationChecker interface {
+	IsRevoked(claims *token.SignedDetails) (bool, error)
+}
+
+// Authentication verifica el token de la solicitud y, si es válido, deja los datos
+// del usuario en el contexto de gin para los handlers que siguen.
+// El token puede venir en el header "token" o como "Authorization: Bearer <token>".
+func Authentication(revocations RevocationChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ClientToken := extractToken(c)
+		if ClientToken == "" {
+			apierrors.Respond(c, apierrors.ErrUnauthenticated)
+			return
+		}
+
+		claims, err := token.ValidateToken(ClientToken)
+		if err != nil {
+			apierrors.Respond(c, err)
+			return
+		}
+
+		// Un token firmado correctamente puede haber sido revocado con logout
+		revoked, err := revocations.IsRevoked(claims)
+		if err != nil {
+			log.Println(err)
+			apierrors.Respond(c, apierrors.Internal("could not verify token"))
+			return
+		}
+		if revoked {
+			apierrors.Respond(c, apierrors.ErrTokenRevoked)
+			return
+		}
+
+		// Guardamos los datos del usuario para que los handlers no tengan que volver a leer el token
+		c.Set("email", claims.Email)
+		c.Set("uid", claims.Uid)
+		c.Set("first_name", claims.First_Name)
+		c.Set("user_type", claims.User_Type)
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// extractToken busca el token primero en el header "token" y luego en el header Authorization.
+func extractToken(c *gin.Context) string {
+	if t := c.Request.Header.Get("token"); t != "" {
+		return t
+	}
+	authHeader := c.Request.Header.Get("Authorization")
+	scheme, t, found := strings.Cut(authHeader, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(t)
+}
+
+// RequireAdmin deja pasar solo a usuarios con rol ADMIN.
+// Debe usarse después de Authentication, que es quien carga el rol en el contexto.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_type") != models.USER_TYPE_ADMIN {
+			apierrors.Respond(c, apierrors.ErrAdminRequired)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+// ErrorResponse es el formato común de todas las respuestas de error de la API:
+//
+//	{"code": "validation_failed", "message": "...", "fields": [{"field": "email", "rule": "email", ...}]}
+//
+// Code es estable y pensado para que el cliente decida qué hacer; Message es para humanos.
+// Fields solo aparece en los errores de validación, con un elemento por campo inválido.
+// Details lleva datos extra de algunos errores (por ejemplo los productos sin stock).
+type ErrorResponse struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	Details interface{}  `json:"details,omitempty"`
+}
+
+// FieldError describe un campo que no pasó la validación: el nombre json del campo
+// (con puntos para los anidados, ej: "price.currency"), la regla que falló, su parámetro
+// y un mensaje para mostrar junto al input.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
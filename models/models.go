@@ -16,60 +16,291 @@ import (
 // almacenando los productos que el usuario tiene en su carrito.
 // Address_Details es una lista o array que contiene objetos de tipo Address, probablemente
 // almacenando la información de direcciones asociadas al usuario.
-// Order_Status es una lista o array que contiene objetos de tipo Order,
-// representando el historial o estado de los pedidos realizados por el usuario.
+// User_Type es el rol del usuario (USER o ADMIN), nunca lo elige el cliente al registrarse.
+// Sessions son las sesiones abiertas, una por login. Los tokens ya no se guardan en el usuario:
+// tokens.Store.StartSession reemplaza a UpdateAllTokens y guarda, por sesión, el jti del refresh
+// token vigente. Los usuarios que iniciaron sesión antes de que hubiera sesiones pueden tener
+// todavía los campos token y refresh_token en la base; solo los lee y los borra el paquete tokens.
+// Las órdenes no se guardan dentro del usuario sino en la colección Orders, con el User_ID
+// del comprador; el historial se obtiene con database.UserOrders.
 type User struct {
 	ID              primitive.ObjectID `json:"_id" bson:"_id"`
 	First_Name      *string            `json:"first_name" validate:"required,min=2,max=30"`
 	Last_Name       *string            `json:"last_name" validate:"required,min=2,max=30"`
-	Password        *string            `json:"password" validate:"required,min=6"`
-	Email           *string            `json:"email" validate:"email, required"`
-	Phone           *string            `json:"phone" validate:"required"`
-	Token           *string            `json:"token"`
-	Refresh_Token   *string            `json:"refresh_token"`
+	Password        *string            `json:"password" validate:"required,min=6,max_bytes=72"`
+	Email           *string            `json:"email" validate:"required,email"`
+	Phone           *string            `json:"phone" validate:"required,phone"`
+	User_Type       *string            `json:"user_type"`
 	Created_At      time.Time          `json:"created_at"`
 	Updated_At      time.Time          `json:"updated_at"`
 	User_ID         string             `json:"user_id"`
 	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
 	Address_Details []Address          `json:"address_details" bson:"address"`
-	Order_Status    []Order            `json:"order_status" bson:"orders"`
+	Sessions        []Session          `json:"-" bson:"sessions,omitempty"`
+}
+
+// Session es una sesión abierta del usuario: nace en cada login (o signup) y dura mientras
+// se rote su refresh token. Refresh_ID es el jti del único refresh token vigente de la sesión;
+// uno anterior de la misma sesión es un token reutilizado.
+type Session struct {
+	Session_ID string    `bson:"_id"`
+	Refresh_ID string    `bson:"refresh_id"`
+	Created_At time.Time `bson:"created_at"`
+	Expires_At time.Time `bson:"expires_at"`
+}
+
+// Roles posibles de un usuario
+const (
+	USER_TYPE_USER  = "USER"
+	USER_TYPE_ADMIN = "ADMIN"
+)
+
+// UserResponse es la versión del usuario que devolvemos al cliente:
+// nunca incluye la contraseña hasheada ni los tokens guardados.
+type UserResponse struct {
+	User_ID    string    `json:"user_id"`
+	First_Name *string   `json:"first_name"`
+	Last_Name  *string   `json:"last_name"`
+	Email      *string   `json:"email"`
+	Phone      *string   `json:"phone"`
+	User_Type  *string   `json:"user_type"`
+	Created_At time.Time `json:"created_at"`
+	Updated_At time.Time `json:"updated_at"`
+}
+
+// NewUserResponse arma el UserResponse a partir del documento del usuario.
+func NewUserResponse(user User) UserResponse {
+	return UserResponse{
+		User_ID:    user.User_ID,
+		First_Name: user.First_Name,
+		Last_Name:  user.Last_Name,
+		Email:      user.Email,
+		Phone:      user.Phone,
+		User_Type:  user.User_Type,
+		Created_At: user.Created_At,
+		Updated_At: user.Updated_At,
+	}
+}
+
+// AuthResponse es lo que devuelven signup y login: el usuario y su par de tokens.
+type AuthResponse struct {
+	User          UserResponse `json:"user"`
+	Token         string       `json:"token"`
+	Refresh_Token string       `json:"refresh_token"`
 }
 
 // Coleccion Products para MongoDB
+// Rating va de 0 a 5 y el precio tiene que ser mayor a cero, en unidades menores y con su moneda.
+// Stock son las unidades disponibles: se descuenta en cada compra y nunca puede quedar en negativo.
 type Products struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name"`
-	Price        *uint64            `json:"price"`
-	Rating       *uint8             `json:"rating"`
-	Image        *string            `json:"image"`
+	Product_ID   primitive.ObjectID `json:"_id" bson:"_id"`
+	Product_Name *string            `json:"product_name" validate:"required,min=2,max=100"`
+	Price        *Money             `json:"price" validate:"required"`
+	Rating       *uint8             `json:"rating" validate:"omitempty,min=0,max=5"`
+	Image        *string            `json:"image" validate:"omitempty,url"`
+	Stock        *int64             `json:"stock" bson:"stock" validate:"omitempty,min=0"`
+}
+
+// StockAdjustment es el registro de auditoría de cada ajuste manual de stock hecho por un admin.
+type StockAdjustment struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	Product_ID  primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Delta       int64              `json:"delta" bson:"delta"`
+	Stock_After *int64             `json:"stock_after" bson:"stock_after"`
+	Reason      string             `json:"reason" bson:"reason"`
+	Admin_ID    string             `json:"admin_id" bson:"admin_id"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
 }
 
 // Coleccion de ProductUser para MongoDB
+// Cada producto aparece una sola vez en el carrito; Quantity indica cuántas unidades lleva.
 type ProductUser struct {
 	Product_ID   primitive.ObjectID `bson:"_id"`
 	Product_Name *string            `json:"product_name" bson:"product_name"`
-	Price        int                `json:"price" bson:"price"`
+	Price        Money              `json:"price" bson:"price"`
 	Rating       *uint8             `json:"rating" bson:"rating"`
 	Image        *string            `json:"image" bson:"image"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+}
+
+// LineTotal es el precio de la línea (precio * cantidad). Las líneas guardadas antes
+// de que existiera Quantity no tienen cantidad y cuentan como una unidad.
+func (p ProductUser) LineTotal() Money {
+	return p.Price.Mul(int64(p.Units()))
+}
+
+// Units devuelve la cantidad de la línea, tratando la cantidad ausente como 1.
+func (p ProductUser) Units() int {
+	if p.Quantity <= 0 {
+		return 1
+	}
+	return p.Quantity
+}
+
+// CartTotal suma el total de todas las líneas. Devuelve ErrCurrencyMismatch
+// si el carrito tiene productos con precios en monedas distintas.
+func CartTotal(cart []ProductUser) (Money, error) {
+	var total Money
+	for _, item := range cart {
+		var err error
+		total, err = total.Add(item.LineTotal())
+		if err != nil {
+			return Money{}, err
+		}
+	}
+	if total.Currency == "" {
+		total.Currency = DefaultCurrency
+	}
+	return total, nil
 }
 
 // Coleccion de Address para MongoDB
+// Las direcciones viven dentro del usuario, en el array "address". House, Street, City y Pincode
+// son obligatorios, igual que Country (código ISO 3166-1 alfa-2, por ejemplo "AR"): el código
+// postal y el teléfono de contacto opcional se validan con el formato de ese país.
+// Label indica si es la de casa, la del trabajo u otra (un usuario tiene a lo sumo una home
+// y una work). Default_Shipping y Default_Billing marcan la dirección que se usa por defecto
+// en el checkout; como mucho una de cada tipo está marcada.
 type Address struct {
-	Address_id primitive.ObjectID `bson:"_id"`
-	House      *string            `json:"house_name" bson:"house_name"`
-	Street     *string            `json:"street_name" bson:"street_name"`
-	City       *string            `json:"city_name" bson:"city_name"`
-	Pincode    *string            `json:"pin_code" bson:"pin_code"`
+	Address_id       primitive.ObjectID `json:"_id" bson:"_id"`
+	Label            AddressLabel       `json:"label" bson:"label" validate:"omitempty,oneof=home work other"`
+	House            *string            `json:"house_name" bson:"house_name" validate:"required,max=100"`
+	Street           *string            `json:"street_name" bson:"street_name" validate:"required,max=100"`
+	City             *string            `json:"city_name" bson:"city_name" validate:"required,max=60"`
+	Pincode          *string            `json:"pin_code" bson:"pin_code" validate:"required,max=20,postal_code=country"`
+	Country          *string            `json:"country" bson:"country" validate:"required,iso3166_1_alpha2"`
+	Phone            *string            `json:"phone,omitempty" bson:"phone,omitempty" validate:"omitempty,phone=country"`
+	Default_Shipping bool               `json:"default_shipping" bson:"default_shipping"`
+	Default_Billing  bool               `json:"default_billing" bson:"default_billing"`
+}
+
+// AddressLabel es la etiqueta de una dirección de la libreta.
+type AddressLabel string
+
+const (
+	ADDRESS_LABEL_HOME  AddressLabel = "home"
+	ADDRESS_LABEL_WORK  AddressLabel = "work"
+	ADDRESS_LABEL_OTHER AddressLabel = "other"
+)
+
+// Snapshot devuelve una copia de la dirección para guardar en una orden. Copia también
+// los valores de los punteros, así editar la libreta después no cambia la orden.
+func (a Address) Snapshot() *Address {
+	snapshot := Address{Address_id: a.Address_id, Label: a.Label}
+	snapshot.House = copyString(a.House)
+	snapshot.Street = copyString(a.Street)
+	snapshot.City = copyString(a.City)
+	snapshot.Pincode = copyString(a.Pincode)
+	snapshot.Country = copyString(a.Country)
+	snapshot.Phone = copyString(a.Phone)
+	return &snapshot
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	value := *s
+	return &value
 }
 
 // Coleccion de Order para MongoDB
+// Cada orden es un documento propio en la colección Orders, asociado al usuario por User_ID.
+// Shipping_Address y Billing_Address son copias de las direcciones elegidas al comprar:
+// editar o borrar la dirección de la libreta no modifica las órdenes ya hechas.
 type Order struct {
-	Order_ID       primitive.ObjectID `bson:"_id"`
-	Order_Cart     []ProductUser      `json:"order_list" bson:"order_list"`
-	Ordered_at     time.Time          `json:"ordered_at" bson:"ordered_at"`
-	Price          int                `json:"total_price" bson:"total_price"`
-	Discount       *int               `json:"discount" bson:"discount"`
-	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
+	Order_ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID          string             `json:"user_id" bson:"user_id"`
+	Order_Cart       []ProductUser      `json:"order_list" bson:"order_list"`
+	Ordered_at       time.Time          `json:"ordered_at" bson:"ordered_at"`
+	Price            Money              `json:"total_price" bson:"total_price"`
+	Discount         *Money             `json:"discount" bson:"discount"`
+	Payment_Method   Payment            `json:"payment_method" bson:"payment_method"`
+	Shipping_Address *Address           `json:"shipping_address" bson:"shipping_address,omitempty"`
+	Billing_Address  *Address           `json:"billing_address" bson:"billing_address,omitempty"`
+	Status           OrderStatus        `json:"status" bson:"status"`
+	Status_History   []StatusChange     `json:"status_history" bson:"status_history"`
+}
+
+// CurrentStatus devuelve el estado de la orden. Las órdenes creadas antes de que
+// existieran los estados no tienen uno guardado y se consideran LegacyOrderStatus.
+func (o Order) CurrentStatus() OrderStatus {
+	if o.Status == "" {
+		return LegacyOrderStatus
+	}
+	return o.Status
+}
+
+// OrderStatus es el estado de una orden dentro de su ciclo de vida.
+type OrderStatus string
+
+const (
+	ORDER_STATUS_PENDING   OrderStatus = "pending"
+	ORDER_STATUS_PAID      OrderStatus = "paid"
+	ORDER_STATUS_SHIPPED   OrderStatus = "shipped"
+	ORDER_STATUS_DELIVERED OrderStatus = "delivered"
+	ORDER_STATUS_CANCELLED OrderStatus = "cancelled"
+	ORDER_STATUS_REFUNDED  OrderStatus = "refunded"
+)
+
+// LegacyOrderStatus es el estado de las órdenes guardadas antes de que existieran los estados.
+// Esas órdenes ya se cobraron y entregaron, y su stock nunca se reservó: no se pueden cancelar.
+// cmd/migrate-orders les guarda este estado explícitamente.
+const LegacyOrderStatus = ORDER_STATUS_DELIVERED
+
+// orderTransitions es el único lugar donde se definen los cambios de estado permitidos:
+//
+//	pending -> paid | cancelled
+//	paid -> shipped | cancelled | refunded
+//	shipped -> delivered
+//	delivered -> refunded
+//
+// cancelled y refunded son estados finales.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	ORDER_STATUS_PENDING:   {ORDER_STATUS_PAID, ORDER_STATUS_CANCELLED},
+	ORDER_STATUS_PAID:      {ORDER_STATUS_SHIPPED, ORDER_STATUS_CANCELLED, ORDER_STATUS_REFUNDED},
+	ORDER_STATUS_SHIPPED:   {ORDER_STATUS_DELIVERED},
+	ORDER_STATUS_DELIVERED: {ORDER_STATUS_REFUNDED},
+	ORDER_STATUS_CANCELLED: {},
+	ORDER_STATUS_REFUNDED:  {},
+}
+
+// IsValid indica si el estado es uno de los conocidos.
+func (s OrderStatus) IsValid() bool {
+	_, ok := orderTransitions[s]
+	return ok
+}
+
+// CanTransitionTo indica si una orden en el estado s puede pasar al estado next.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusesBefore devuelve los estados desde los que se puede llegar a next.
+// Sirve para hacer el cambio de estado con un update condicional.
+func StatusesBefore(next OrderStatus) []OrderStatus {
+	var from []OrderStatus
+	for status, targets := range orderTransitions {
+		for _, target := range targets {
+			if target == next {
+				from = append(from, status)
+			}
+		}
+	}
+	return from
+}
+
+// StatusChange registra cada cambio de estado de la orden: a qué estado pasó, cuándo y quién lo hizo.
+type StatusChange struct {
+	Status OrderStatus `json:"status" bson:"status"`
+	At     time.Time   `json:"at" bson:"at"`
+	By     string      `json:"by,omitempty" bson:"by,omitempty"`
+	Reason string      `json:"reason,omitempty" bson:"reason,omitempty"`
 }
 
 // COD = Cash on Delivery
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"sort"
+	"testing"
+)
+
+var allOrderStatuses = []OrderStatus{
+	ORDER_STATUS_PENDING,
+	ORDER_STATUS_PAID,
+	ORDER_STATUS_SHIPPED,
+	ORDER_STATUS_DELIVERED,
+	ORDER_STATUS_CANCELLED,
+	ORDER_STATUS_REFUNDED,
+}
+
+// allowedTransitions repite a mano el diagrama de orderTransitions; cualquier par que no esté acá
+// tiene que ser rechazado.
+var allowedTransitions = map[[2]OrderStatus]bool{
+	{ORDER_STATUS_PENDING, ORDER_STATUS_PAID}:       true,
+	{ORDER_STATUS_PENDING, ORDER_STATUS_CANCELLED}:  true,
+	{ORDER_STATUS_PAID, ORDER_STATUS_SHIPPED}:       true,
+	{ORDER_STATUS_PAID, ORDER_STATUS_CANCELLED}:     true,
+	{ORDER_STATUS_PAID, ORDER_STATUS_REFUNDED}:      true,
+	{ORDER_STATUS_SHIPPED, ORDER_STATUS_DELIVERED}:  true,
+	{ORDER_STATUS_DELIVERED, ORDER_STATUS_REFUNDED}: true,
+}
+
+func TestOrderStatusCanTransitionTo(t *testing.T) {
+	for _, from := range allOrderStatuses {
+		for _, to := range allOrderStatuses {
+			want := allowedTransitions[[2]OrderStatus{from, to}]
+			t.Run(string(from)+"->"+string(to), func(t *testing.T) {
+				if got := from.CanTransitionTo(to); got != want {
+					t.Errorf("CanTransitionTo() = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestOrderStatusUnknown(t *testing.T) {
+	unknown := OrderStatus("lost")
+	if unknown.IsValid() {
+		t.Error("IsValid() = true for an unknown status")
+	}
+	for _, status := range allOrderStatuses {
+		if !status.IsValid() {
+			t.Errorf("%s.IsValid() = false", status)
+		}
+		if unknown.CanTransitionTo(status) {
+			t.Errorf("lost -> %s allowed", status)
+		}
+		if status.CanTransitionTo(unknown) {
+			t.Errorf("%s -> lost allowed", status)
+		}
+	}
+	if from := StatusesBefore(unknown); len(from) != 0 {
+		t.Errorf("StatusesBefore(lost) = %v, want none", from)
+	}
+}
+
+func TestStatusesBefore(t *testing.T) {
+	tests := []struct {
+		to   OrderStatus
+		want []OrderStatus
+	}{
+		{ORDER_STATUS_PENDING, nil},
+		{ORDER_STATUS_PAID, []OrderStatus{ORDER_STATUS_PENDING}},
+		{ORDER_STATUS_SHIPPED, []OrderStatus{ORDER_STATUS_PAID}},
+		{ORDER_STATUS_DELIVERED, []OrderStatus{ORDER_STATUS_SHIPPED}},
+		{ORDER_STATUS_CANCELLED, []OrderStatus{ORDER_STATUS_PAID, ORDER_STATUS_PENDING}},
+		{ORDER_STATUS_REFUNDED, []OrderStatus{ORDER_STATUS_DELIVERED, ORDER_STATUS_PAID}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.to), func(t *testing.T) {
+			got := StatusesBefore(tt.to)
+			// El orden depende de la iteración del map
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if len(got) != len(tt.want) {
+				t.Fatalf("StatusesBefore() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("StatusesBefore() = %v, want %v", got, tt.want)
+				}
+			}
+			// Tiene que coincidir con CanTransitionTo para cada estado de origen
+			for _, from := range allOrderStatuses {
+				listed := false
+				for _, status := range got {
+					listed = listed || status == from
+				}
+				if listed != from.CanTransitionTo(tt.to) {
+					t.Errorf("%s listed = %v, CanTransitionTo() = %v", from, listed, from.CanTransitionTo(tt.to))
+				}
+			}
+		})
+	}
+}
+
+func TestOrderCurrentStatus(t *testing.T) {
+	if got := (Order{}).CurrentStatus(); got != ORDER_STATUS_DELIVERED {
+		t.Errorf("CurrentStatus() without status = %s, want %s", got, ORDER_STATUS_DELIVERED)
+	}
+	if got := (Order{Status: ORDER_STATUS_SHIPPED}).CurrentStatus(); got != ORDER_STATUS_SHIPPED {
+		t.Errorf("CurrentStatus() = %s, want %s", got, ORDER_STATUS_SHIPPED)
+	}
+}
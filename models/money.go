@@ -0,0 +1,177 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// DefaultCurrency es la moneda que se asume cuando un precio no trae una
+// (por ejemplo los precios guardados antes de que existiera Money).
+var DefaultCurrency = "USD"
+
+// ErrCurrencyMismatch se devuelve al operar con montos de monedas distintas.
+var ErrCurrencyMismatch = errors.New("cannot operate on amounts in different currencies")
+
+// Money es un monto en unidades menores (centavos para USD, yenes para JPY) con su código ISO 4217.
+// Usar enteros evita los errores de redondeo de los float.
+// En JSON se devuelve como {"amount": 1999, "currency": "USD", "formatted": "19.99 USD"}.
+type Money struct {
+	Amount   int64  `json:"amount" bson:"amount" validate:"gt=0"`
+	Currency string `json:"currency" bson:"currency" validate:"required,iso4217"`
+}
+
+// minorUnits son los decimales de las monedas que no usan 2. El resto usa 2.
+var minorUnits = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+}
+
+// NewMoney crea un monto en unidades menores; sin moneda se usa DefaultCurrency.
+func NewMoney(amount int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// LegacyMoney convierte un precio guardado antes de Money: un número entero de unidades
+// enteras (dólares, pesos) en DefaultCurrency. cmd/backfill-prices usa esta misma conversión
+// para reescribir los precios viejos de la base.
+func LegacyMoney(units int64) Money {
+	m := NewMoney(0, "")
+	m.Amount = units * minorFactor(m.Currency)
+	return m
+}
+
+// minorFactor es la cantidad de unidades menores que tiene una unidad de la moneda (100 para USD).
+func minorFactor(currency string) int64 {
+	decimals, ok := minorUnits[currency]
+	if !ok {
+		decimals = 2
+	}
+	factor := int64(1)
+	for i := 0; i < decimals; i++ {
+		factor *= 10
+	}
+	return factor
+}
+
+// Add suma dos montos de la misma moneda. Un monto vacío (Money{}) toma la moneda del otro.
+func (m Money) Add(other Money) (Money, error) {
+	switch {
+	case m.Currency == "":
+		return Money{Amount: m.Amount + other.Amount, Currency: other.Currency}, nil
+	case other.Currency == "" || other.Currency == m.Currency:
+		return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+	default:
+		return Money{}, ErrCurrencyMismatch
+	}
+}
+
+// Sub resta dos montos de la misma moneda.
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul multiplica el monto por una cantidad (por ejemplo, unidades de una línea del carrito).
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// String formatea el monto con los decimales de su moneda, por ejemplo "19.99 USD".
+func (m Money) String() string {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	decimals, ok := minorUnits[currency]
+	if !ok {
+		decimals = 2
+	}
+
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, currency)
+	}
+	divisor := minorFactor(currency)
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/divisor, decimals, amount%divisor, currency)
+}
+
+// MarshalJSON devuelve el monto en unidades menores y también formateado.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount    int64  `json:"amount"`
+		Currency  string `json:"currency"`
+		Formatted string `json:"formatted"`
+	}{m.Amount, m.Currency, m.String()})
+}
+
+// UnmarshalJSON acepta {"amount": 1999, "currency": "USD"} o, por compatibilidad,
+// un número entero solo, que es un precio viejo en unidades enteras (ver LegacyMoney).
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		var amount int64
+		if err := json.Unmarshal(data, &amount); err != nil {
+			return errors.New("money must be an object with amount and currency or an integer amount")
+		}
+		*m = LegacyMoney(amount)
+		return nil
+	}
+
+	var value struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*m = NewMoney(value.Amount, value.Currency)
+	return nil
+}
+
+// UnmarshalBSONValue lee el subdocumento {amount, currency}. Los precios guardados antes
+// de Money eran un número entero suelto: se leen con LegacyMoney. Un número con decimales
+// no puede ser uno de esos precios y se rechaza en lugar de truncarlo.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.EmbeddedDocument:
+		// money no tiene métodos, así Unmarshal no vuelve a llamar a UnmarshalBSONValue
+		type money Money
+		var value money
+		if err := raw.Unmarshal(&value); err != nil {
+			return err
+		}
+		*m = Money(value)
+	case bsontype.Int32, bsontype.Int64:
+		units, ok := raw.AsInt64OK()
+		if !ok {
+			return fmt.Errorf("cannot read money amount from %s", t)
+		}
+		*m = LegacyMoney(units)
+	case bsontype.Double:
+		value := raw.Double()
+		if value != math.Trunc(value) || math.Abs(value) > math.MaxInt64/1000 {
+			return fmt.Errorf("cannot read money amount from %v: legacy prices are whole numbers", value)
+		}
+		*m = LegacyMoney(int64(value))
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+	default:
+		return fmt.Errorf("cannot decode money from %s", t)
+	}
+	return nil
+}
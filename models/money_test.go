@@ -0,0 +1,153 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    Money
+		wantErr error
+	}{
+		{"add same currency", func() (Money, error) { return NewMoney(1999, "USD").Add(NewMoney(1, "USD")) }, NewMoney(2000, "USD"), nil},
+		{"add to empty money takes the other currency", func() (Money, error) { return Money{}.Add(NewMoney(500, "ARS")) }, NewMoney(500, "ARS"), nil},
+		{"add empty money keeps the currency", func() (Money, error) { return NewMoney(500, "ARS").Add(Money{}) }, NewMoney(500, "ARS"), nil},
+		{"add different currencies", func() (Money, error) { return NewMoney(100, "USD").Add(NewMoney(100, "EUR")) }, Money{}, ErrCurrencyMismatch},
+		{"sub same currency", func() (Money, error) { return NewMoney(1000, "USD").Sub(NewMoney(250, "USD")) }, NewMoney(750, "USD"), nil},
+		{"sub below zero", func() (Money, error) { return NewMoney(100, "USD").Sub(NewMoney(250, "USD")) }, NewMoney(-150, "USD"), nil},
+		{"sub different currencies", func() (Money, error) { return NewMoney(1000, "JPY").Sub(NewMoney(1, "USD")) }, Money{}, ErrCurrencyMismatch},
+		{"mul by quantity", func() (Money, error) { return NewMoney(1999, "USD").Mul(3), nil }, NewMoney(5997, "USD"), nil},
+		{"mul by zero", func() (Money, error) { return NewMoney(1999, "USD").Mul(0), nil }, NewMoney(0, "USD"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(1999, "USD"), "19.99 USD"},
+		{NewMoney(5, "USD"), "0.05 USD"},
+		{NewMoney(0, "USD"), "0.00 USD"},
+		{NewMoney(-1999, "USD"), "-19.99 USD"},
+		{NewMoney(-5, "EUR"), "-0.05 EUR"},
+		{NewMoney(1500, "JPY"), "1500 JPY"},
+		{NewMoney(-1500, "CLP"), "-1500 CLP"},
+		{NewMoney(1234, "KWD"), "1.234 KWD"},
+		{NewMoney(5, "BHD"), "0.005 BHD"},
+		{NewMoney(-12005, "JOD"), "-12.005 JOD"},
+		{Money{Amount: 250}, "2.50 " + DefaultCurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Money
+		wantErr bool
+	}{
+		{"object", `{"amount": 1999, "currency": "USD"}`, NewMoney(1999, "USD"), false},
+		{"object with lowercase currency", `{"amount": 1500, "currency": "jpy"}`, NewMoney(1500, "JPY"), false},
+		{"object without currency", `{"amount": 1999}`, NewMoney(1999, DefaultCurrency), false},
+		{"object with formatted", `{"amount": 1999, "currency": "EUR", "formatted": "19.99 EUR"}`, NewMoney(1999, "EUR"), false},
+		{"legacy number is in whole units", `1999`, NewMoney(199900, DefaultCurrency), false},
+		{"legacy number with spaces", ` 42 `, NewMoney(4200, DefaultCurrency), false},
+		{"legacy decimal number", `19.99`, Money{}, true},
+		{"string", `"19.99"`, Money{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalBSONValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		price   interface{}
+		want    Money
+		wantErr bool
+	}{
+		{"subdocument", bson.D{{Key: "amount", Value: int64(1999)}, {Key: "currency", Value: "EUR"}}, NewMoney(1999, "EUR"), false},
+		{"legacy int32 is in whole units", int32(1500), NewMoney(150000, DefaultCurrency), false},
+		{"legacy int64", int64(1999), NewMoney(199900, DefaultCurrency), false},
+		{"legacy whole double", float64(1999), NewMoney(199900, DefaultCurrency), false},
+		{"legacy fractional double", 19.99, Money{}, true},
+		{"null", nil, Money{}, false},
+		{"string", "19.99", Money{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(bson.D{{Key: "price", Value: tt.price}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Price Money `bson:"price"`
+			}
+			err = bson.Unmarshal(data, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Price != tt.want {
+				t.Errorf("got %+v, want %+v", got.Price, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyMoney(t *testing.T) {
+	defer func(currency string) { DefaultCurrency = currency }(DefaultCurrency)
+
+	tests := []struct {
+		currency string
+		units    int64
+		want     Money
+	}{
+		{"USD", 1500, NewMoney(150000, "USD")},
+		{"JPY", 1500, NewMoney(1500, "JPY")},
+		{"KWD", 3, NewMoney(3000, "KWD")},
+		{"ARS", 0, NewMoney(0, "ARS")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			DefaultCurrency = tt.currency
+			if got := LegacyMoney(tt.units); got != tt.want {
+				t.Errorf("LegacyMoney(%d) = %+v, want %+v", tt.units, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,68 @@
 package routes
 
 import (
-	"github.com/FrancoRutigliano/EcommercerGolang/controllers"
+	"github.com/FrancoRutigliano/EcommerceGolang/controllers"
+	"github.com/FrancoRutigliano/EcommerceGolang/middleware"
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", controllers.Signup())
-	incomingRoutes.POST("/users/login", controllers.Login())
-	incomingRoutes.POST("admin/addproduct", controllers.ProductViewAdmin())
+// SetupRouter arma el router completo de la API. Lo comparten main.go y los tests,
+// así las rutas y los middlewares se registran en un único lugar. La lista de revocación que
+// consulta middleware.Authentication sale de app.Revocations().
+func SetupRouter(app *controllers.Application) *gin.Engine {
+	revocations := app.Revocations()
+
+	router := gin.New()
+	router.Use(gin.Logger())
+	router.Use(gin.Recovery())
+
+	// Rutas públicas: no requieren token
+	UserRoutes(router.Group("/"), app)
+
+	// Rutas que requieren un usuario autenticado
+	authenticated := router.Group("/")
+	authenticated.Use(middleware.Authentication(revocations))
+	AuthenticatedRoutes(authenticated, app)
+
+	// Rutas de administración: usuario autenticado y con rol ADMIN
+	admin := router.Group("/admin")
+	admin.Use(middleware.Authentication(revocations), middleware.RequireAdmin())
+	AdminRoutes(admin, app)
+
+	return router
+}
+
+func UserRoutes(incomingRoutes *gin.RouterGroup, app *controllers.Application) {
+	incomingRoutes.POST("/users/signup", app.Signup())
+	incomingRoutes.POST("/users/login", app.Login())
+	incomingRoutes.POST("/users/refresh", app.RefreshToken())
 	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
 	incomingRoutes.GET("/users/search", controllers.SearchProductByQuerie())
 }
+
+func AuthenticatedRoutes(incomingRoutes *gin.RouterGroup, app *controllers.Application) {
+	incomingRoutes.POST("/users/logout", app.Logout())
+	incomingRoutes.POST("/users/logout-all", app.LogoutAll())
+	incomingRoutes.GET("/addtocart", app.AddToCart())
+	incomingRoutes.GET("/removeitem", app.RemoveItem())
+	incomingRoutes.PATCH("/cart/quantity", app.UpdateCartQuantity())
+	incomingRoutes.GET("/listcart", app.GetItemFromCart())
+	incomingRoutes.GET("/cartcheckout", app.BuyFromCart())
+	incomingRoutes.GET("/instantbuy", app.InstantBuy())
+	incomingRoutes.GET("/orders", app.ListOrders())
+	incomingRoutes.GET("/orders/:id", app.GetOrder())
+	incomingRoutes.POST("/orders/:id/cancel", app.CancelOrder())
+	incomingRoutes.GET("/addresses", app.ListAddresses())
+	incomingRoutes.POST("/addaddress", app.AddAddress())
+	incomingRoutes.PUT("/edithomeaddress", app.EditHomeAddress())
+	incomingRoutes.PUT("/editworkaddress", app.EditWorkAddress())
+	incomingRoutes.DELETE("/deleteaddress", app.DeleteAddress())
+	incomingRoutes.PUT("/addresses/:id/default", app.SetDefaultAddress())
+}
+
+func AdminRoutes(incomingRoutes *gin.RouterGroup, app *controllers.Application) {
+	incomingRoutes.POST("/addproduct", controllers.ProductViewAdmin())
+	incomingRoutes.POST("/products/:id/stock", app.AdjustStock())
+	incomingRoutes.GET("/products/:id/stock/adjustments", app.StockAdjustments())
+	incomingRoutes.POST("/orders/:id/status", app.UpdateOrderStatus())
+}
@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/controllers"
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"github.com/FrancoRutigliano/EcommerceGolang/tokens"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// revocationList es una lista de revocación en memoria, por jti.
+type revocationList map[string]bool
+
+func (r revocationList) IsRevoked(claims *tokens.SignedDetails) (bool, error) {
+	return r[claims.ID], nil
+}
+
+// newTestRouter arma el router sin base de datos: estos tests solo llegan hasta los middlewares.
+func newTestRouter(revoked revocationList) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	app := controllers.NewApplication(nil, nil, nil, nil, nil)
+	app.SetRevocations(revoked)
+	return SetupRouter(app)
+}
+
+func accessToken(t *testing.T, userType string) (string, *tokens.SignedDetails) {
+	t.Helper()
+	signed, _, err := tokens.TokenGenerator("ana@example.com", "Ana", "Diaz", primitive.NewObjectID().Hex(), userType, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.ValidateToken(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed, claims
+}
+
+func serve(router *gin.Engine, method, path, token string) (int, models.ErrorResponse) {
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	var body models.ErrorResponse
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return recorder.Code, body
+}
+
+func TestSetupRouterWithoutToken(t *testing.T) {
+	router := newTestRouter(revocationList{})
+
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/listcart"},
+		{http.MethodGet, "/cartcheckout"},
+		{http.MethodGet, "/orders"},
+		{http.MethodPost, "/users/logout"},
+		{http.MethodPost, "/admin/addproduct"},
+		{http.MethodPost, "/admin/orders/" + primitive.NewObjectID().Hex() + "/status"},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			status, body := serve(router, route.method, route.path, "")
+			if status != http.StatusUnauthorized || body.Code != "unauthenticated" {
+				t.Errorf("got %d %q, want 401 \"unauthenticated\"", status, body.Code)
+			}
+		})
+	}
+}
+
+func TestSetupRouterAdminRoutes(t *testing.T) {
+	userToken, _ := accessToken(t, models.USER_TYPE_USER)
+	revokedToken, revokedClaims := accessToken(t, models.USER_TYPE_ADMIN)
+	router := newTestRouter(revocationList{revokedClaims.ID: true})
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"user is not an admin", userToken, http.StatusForbidden, "admin_required"},
+		{"revoked admin token", revokedToken, http.StatusUnauthorized, "token_revoked"},
+		{"malformed token", "not-a-jwt", http.StatusUnauthorized, "token_malformed"},
+	}
+	paths := []string{
+		"/admin/addproduct",
+		"/admin/products/" + primitive.NewObjectID().Hex() + "/stock",
+		"/admin/orders/" + primitive.NewObjectID().Hex() + "/status",
+	}
+	for _, tt := range tests {
+		for _, path := range paths {
+			t.Run(tt.name+" "+path, func(t *testing.T) {
+				status, body := serve(router, http.MethodPost, path, tt.token)
+				if status != tt.wantStatus || body.Code != tt.wantCode {
+					t.Errorf("got %d %q, want %d %q", status, body.Code, tt.wantStatus, tt.wantCode)
+				}
+			})
+		}
+	}
+}
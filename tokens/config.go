@@ -0,0 +1,75 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+)
+
+// Config agrupa las claves de firma y la duración de los tokens.
+// Se lee de variables de entorno para que staging y producción puedan usar valores distintos:
+//
+//	SECRET_KEY          clave HMAC para firmar los access tokens
+//	REFRESH_SECRET_KEY  clave HMAC para firmar los refresh tokens
+//	ACCESS_TOKEN_TTL    duración del access token (formato time.ParseDuration, ej: "24h")
+//	REFRESH_TOKEN_TTL   duración del refresh token (ej: "168h")
+type Config struct {
+	AccessSecret  []byte
+	RefreshSecret []byte
+	AccessTTL     time.Duration
+	RefreshTTL    time.Duration
+}
+
+// Valores por defecto en caso de que no se definan las variables de entorno
+const (
+	defaultAccessTTL  = 24 * time.Hour
+	defaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+// config es la configuración activa del paquete, cargada una sola vez al iniciar.
+var config = LoadConfig()
+
+// LoadConfig construye la configuración a partir de las variables de entorno.
+func LoadConfig() Config {
+	return Config{
+		AccessSecret:  secretFromEnv("SECRET_KEY"),
+		RefreshSecret: secretFromEnv("REFRESH_SECRET_KEY"),
+		AccessTTL:     durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTTL),
+		RefreshTTL:    durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTTL),
+	}
+}
+
+// SetConfig reemplaza la configuración activa (útil para tests o para recargar claves).
+func SetConfig(c Config) {
+	config = c
+}
+
+// secretFromEnv lee una clave de firma. Si no está definida, generamos una aleatoria
+// para no firmar nunca con una clave vacía; los tokens dejarán de ser válidos al reiniciar.
+func secretFromEnv(name string) []byte {
+	if value := os.Getenv(name); value != "" {
+		return []byte(value)
+	}
+	log.Printf("%s no está definida, se usa una clave aleatoria para este proceso", name)
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal(err)
+	}
+	return []byte(hex.EncodeToString(key))
+}
+
+// durationFromEnv lee una duración; si no existe o es inválida se usa el valor por defecto.
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("%s inválida (%q), se usa %s", name, value, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,128 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// La lista de revocación (Store.revokedCollection) tiene documentos de estas formas:
+//
+//	{_id: <jti>, user_id, expires_at}                       revoca un token puntual
+//	{_id: "session:<sid>", user_id, expires_at}             revoca los tokens de una sesión (ver RevokeSession)
+//	{_id: "all:<uid>", user_id, revoked_before, expires_at} revoca todo lo emitido antes de revoked_before
+//
+// expires_at tiene un índice TTL, así mongo borra las entradas cuando el token ya venció de todas formas.
+
+// EnsureRevocationIndexes crea el índice TTL sobre expires_at. Es idempotente, se llama al iniciar.
+func (s *Store) EnsureRevocationIndexes() error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.revokedCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{primitive.E{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_at_ttl"),
+	})
+	return err
+}
+
+// RevokeToken agrega el token (identificado por su jti) a la lista de revocación hasta que expire.
+func (s *Store) RevokeToken(claims *SignedDetails) error {
+	if claims.ID == "" {
+		return ErrTokenInvalid
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expiresAt := time.Now().Add(config.AccessTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	_, err := s.revokedCollection.UpdateOne(ctx,
+		bson.D{primitive.E{Key: "_id", Value: claims.ID}},
+		bson.D{{Key: "$set", Value: bson.D{
+			primitive.E{Key: "user_id", Value: claims.Uid},
+			primitive.E{Key: "expires_at", Value: expiresAt},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// RevokeAllSessions invalida todos los tokens emitidos hasta ahora para el usuario
+// y borra todas sus sesiones, así tampoco se puede rotar ningún refresh token.
+// Cada sesión borrada queda además en la lista de revocación: revoked_before tiene precisión
+// de segundos y no alcanza para los tokens emitidos en el mismo segundo que el logout.
+func (s *Store) RevokeAllSessions(userid string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// iat tiene precisión de segundos, así que guardamos el segundo truncado e IsRevoked compara con $gt:
+	// queda revocado lo emitido en segundos anteriores y un login hecho justo después sigue valiendo.
+	// Los tokens de este mismo segundo los cubren las entradas por sesión
+	now := time.Now().Truncate(time.Second)
+	// La entrada tiene que vivir tanto como el token más largo que pudimos haber emitido
+	ttl := config.RefreshTTL
+	if config.AccessTTL > ttl {
+		ttl = config.AccessTTL
+	}
+	_, err := s.revokedCollection.UpdateOne(ctx,
+		bson.D{primitive.E{Key: "_id", Value: "all:" + userid}},
+		bson.D{{Key: "$set", Value: bson.D{
+			primitive.E{Key: "user_id", Value: userid},
+			primitive.E{Key: "revoked_before", Value: now},
+			primitive.E{Key: "expires_at", Value: now.Add(ttl)},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	cleared, err := s.clearSessions(ctx, userid)
+	if err != nil {
+		return err
+	}
+	sessions := make([]string, 0, len(cleared))
+	for _, session := range cleared {
+		sessions = append(sessions, session.Session_ID)
+	}
+	return s.revokeSessions(ctx, userid, sessions)
+}
+
+// IsRevoked indica si el token fue revocado puntualmente, junto con su sesión
+// o por un "cerrar todas las sesiones".
+func (s *Store) IsRevoked(claims *SignedDetails) (bool, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	conditions := bson.A{
+		bson.D{primitive.E{Key: "_id", Value: claims.ID}},
+		bson.D{
+			primitive.E{Key: "_id", Value: "all:" + claims.Uid},
+			primitive.E{Key: "revoked_before", Value: bson.D{primitive.E{Key: "$gt", Value: issuedAt}}},
+		},
+	}
+	if claims.Session != "" {
+		conditions = append(conditions, bson.D{primitive.E{Key: "_id", Value: "session:" + claims.Session}})
+	}
+	filter := bson.D{{Key: "$or", Value: conditions}}
+
+	err := s.revokedCollection.FindOne(ctx, filter).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,233 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/FrancoRutigliano/EcommerceGolang/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cada login abre una sesión y cada sesión es una familia de refresh tokens: al rotar, el
+// refresh token nuevo hereda la sesión del anterior. En el usuario se guarda, por sesión, el jti
+// del refresh token vigente, así un login en otro dispositivo no invalida a los demás y solo
+// un refresh token que su propia sesión ya dejó atrás se considera reutilizado.
+
+// NewSession arma la sesión que abre el refresh token recién emitido con TokenGenerator.
+func NewSession(signedrefreshtoken string) (models.Session, error) {
+	claims, err := ValidateRefreshToken(signedrefreshtoken)
+	if err != nil {
+		return models.Session{}, err
+	}
+	return models.Session{
+		Session_ID: claims.Session,
+		Refresh_ID: claims.ID,
+		Created_At: time.Now(),
+		Expires_At: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// StartSession agrega al usuario la sesión del refresh token y actualiza su updated_at; es lo que
+// reemplaza a UpdateAllTokens, que guardaba el par completo en el usuario. En el mismo update se
+// borran las sesiones vencidas, así la lista no crece con cada login.
+func (s *Store) StartSession(userid string, signedrefreshtoken string) error {
+	session, err := NewSession(signedrefreshtoken)
+	if err != nil {
+		return err
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	active := bson.D{primitive.E{Key: "$filter", Value: bson.D{
+		primitive.E{Key: "input", Value: bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$sessions", bson.A{}}}}},
+		primitive.E{Key: "cond", Value: bson.D{primitive.E{Key: "$gt", Value: bson.A{"$$this.expires_at", now}}}},
+	}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "sessions", Value: bson.D{primitive.E{Key: "$concatArrays", Value: bson.A{
+			active,
+			bson.D{primitive.E{Key: "$literal", Value: bson.A{session}}},
+		}}}},
+		primitive.E{Key: "updated_at", Value: updated_at},
+	}}}}
+
+	result, err := s.userCollection.UpdateOne(ctx, bson.D{primitive.E{Key: "user_id", Value: userid}}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// RotateSession reemplaza el refresh token vigente de una sesión: oldrefreshtoken deja de servir
+// y newrefreshtoken, emitido con TokenGenerator para la misma sesión, pasa a ser el vigente.
+// El update es condicional sobre el jti vigente, así dos solicitudes con el mismo refresh token
+// nunca obtienen dos pares válidos. Si no se puede rotar devuelve ErrSessionEnded cuando la
+// sesión ya no existe (logout o vencida) y ErrRefreshTokenReused cuando la sesión sigue abierta
+// pero ya rotó más allá de oldrefreshtoken.
+// Los refresh tokens emitidos antes de que existieran las sesiones se comparan con el que
+// quedó guardado en el usuario y, si coinciden, se convierten en una sesión nueva.
+func (s *Store) RotateSession(oldrefreshtoken string, newrefreshtoken string) error {
+	old, err := ValidateRefreshToken(oldrefreshtoken)
+	if err != nil {
+		return err
+	}
+	session, err := NewSession(newrefreshtoken)
+	if err != nil {
+		return err
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if old.Session == "" {
+		filter := bson.D{
+			primitive.E{Key: "user_id", Value: old.Uid},
+			primitive.E{Key: "refresh_token", Value: oldrefreshtoken},
+		}
+		update := bson.D{
+			{Key: "$unset", Value: bson.D{primitive.E{Key: "token", Value: ""}, primitive.E{Key: "refresh_token", Value: ""}}},
+			{Key: "$push", Value: bson.D{primitive.E{Key: "sessions", Value: session}}},
+			{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: updated_at}}},
+		}
+		result, err := s.userCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			// Sin sesión no se puede saber si fue un logout o una reutilización
+			return ErrSessionEnded
+		}
+		return nil
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: old.Uid},
+		primitive.E{Key: "sessions", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{
+			primitive.E{Key: "_id", Value: old.Session},
+			primitive.E{Key: "refresh_id", Value: old.ID},
+		}}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "sessions.$.refresh_id", Value: session.Refresh_ID},
+		primitive.E{Key: "sessions.$.expires_at", Value: session.Expires_At},
+		primitive.E{Key: "updated_at", Value: updated_at},
+	}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := s.userCollection.CountDocuments(ctx, bson.D{
+		primitive.E{Key: "user_id", Value: old.Uid},
+		primitive.E{Key: "sessions._id", Value: old.Session},
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSessionEnded
+	}
+	return ErrRefreshTokenReused
+}
+
+// RevokeSession cierra una sesión: borra su refresh token vigente y agrega la sesión a la lista
+// de revocación, así tampoco sirven los access tokens que se emitieron en ella.
+func (s *Store) RevokeSession(userid string, session string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.revokeSessions(ctx, userid, []string{session}); err != nil {
+		return err
+	}
+
+	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := s.userCollection.UpdateOne(ctx,
+		bson.D{primitive.E{Key: "user_id", Value: userid}},
+		bson.D{
+			{Key: "$pull", Value: bson.D{primitive.E{Key: "sessions", Value: bson.D{primitive.E{Key: "_id", Value: session}}}}},
+			{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: updated_at}}},
+		},
+	)
+	return err
+}
+
+// revokeSessions agrega las sesiones a la lista de revocación. Los access tokens de una sesión
+// vencen a más tardar AccessTTL después de su último refresh, así que la entrada dura eso.
+func (s *Store) revokeSessions(ctx context.Context, userid string, sessions []string) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(config.AccessTTL)
+	writes := make([]mongo.WriteModel, 0, len(sessions))
+	for _, session := range sessions {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{primitive.E{Key: "_id", Value: "session:" + session}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				primitive.E{Key: "user_id", Value: userid},
+				primitive.E{Key: "expires_at", Value: expiresAt},
+			}}}).
+			SetUpsert(true))
+	}
+	_, err := s.revokedCollection.BulkWrite(ctx, writes)
+	return err
+}
+
+// EndSession cierra la sesión del token con el que se hizo la solicitud (logout): revoca el token y
+// la sesión a la que pertenece. Las demás sesiones del usuario siguen abiertas. Los tokens emitidos
+// antes de que existieran las sesiones no tienen sesión: para ellos se borra el par guardado.
+func (s *Store) EndSession(claims *SignedDetails) error {
+	if err := s.RevokeToken(claims); err != nil {
+		return err
+	}
+	if claims.Session != "" {
+		return s.RevokeSession(claims.Uid, claims.Session)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "token", Value: ""}, primitive.E{Key: "refresh_token", Value: ""}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: updated_at}}},
+	}
+	_, err := s.userCollection.UpdateOne(ctx, bson.D{primitive.E{Key: "user_id", Value: claims.Uid}}, update)
+	return err
+}
+
+// clearSessions borra todas las sesiones del usuario (y el par guardado por los usuarios viejos),
+// así ningún refresh token del usuario podrá volver a rotarse, y devuelve las sesiones borradas.
+// Leer y borrar es un único update: una sesión abierta justo después no se pierde.
+func (s *Store) clearSessions(ctx context.Context, userid string) ([]models.Session, error) {
+	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{
+			primitive.E{Key: "token", Value: ""},
+			primitive.E{Key: "refresh_token", Value: ""},
+			primitive.E{Key: "sessions", Value: ""},
+		}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: updated_at}}},
+	}
+	var before struct {
+		Sessions []models.Session `bson:"sessions"`
+	}
+	findOptions := options.FindOneAndUpdate().
+		SetReturnDocument(options.Before).
+		SetProjection(bson.D{primitive.E{Key: "sessions", Value: 1}})
+	err := s.userCollection.FindOneAndUpdate(ctx, bson.D{primitive.E{Key: "user_id", Value: userid}}, update, findOptions).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return before.Sessions, err
+}
@@ -0,0 +1,195 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Estos tests necesitan un mongod; cualquiera sirve, usamos la misma variable que los tests de database:
+//
+//	MONGODB_STANDALONE_URI=mongodb://localhost:27018 go test ./tokens/
+//
+// Sin MONGODB_STANDALONE_URI se saltean. Cada test usa una base de datos propia que se borra al final.
+
+func testStore(t *testing.T) *Store {
+	t.Helper()
+	uri := os.Getenv("MONGODB_STANDALONE_URI")
+	if uri == "" {
+		t.Skip("MONGODB_STANDALONE_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	db := client.Database("ecommerce_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return NewStore(db.Collection("Users"), db.Collection("RevokedTokens"))
+}
+
+// newUser guarda un usuario sin sesiones y devuelve su user_id.
+func newUser(t *testing.T, s *Store) string {
+	t.Helper()
+	id := primitive.NewObjectID()
+	_, err := s.userCollection.InsertOne(context.Background(), bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "user_id", Value: id.Hex()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id.Hex()
+}
+
+func pairFor(t *testing.T, uid string, session string) (*SignedDetails, string) {
+	t.Helper()
+	signedtoken, signedrefreshtoken, err := TokenGenerator("ana@example.com", "Ana", "Diaz", uid, "USER", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateToken(signedtoken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims, signedrefreshtoken
+}
+
+func TestRotateSession(t *testing.T) {
+	useTestConfig(t)
+	s := testStore(t)
+	uid := newUser(t, s)
+
+	first, refresh1 := pairFor(t, uid, "")
+	if err := s.StartSession(uid, refresh1); err != nil {
+		t.Fatal(err)
+	}
+	// Otro login del mismo usuario es otra sesión y no se entera de lo que pasa con la primera
+	other, otherRefresh := pairFor(t, uid, "")
+	if err := s.StartSession(uid, otherRefresh); err != nil {
+		t.Fatal(err)
+	}
+
+	_, refresh2 := pairFor(t, uid, first.Session)
+	_, refresh3 := pairFor(t, uid, first.Session)
+
+	steps := []struct {
+		name     string
+		old, new string
+		want     error
+	}{
+		{"current refresh token", refresh1, refresh2, nil},
+		{"rotated refresh token is reused", refresh1, refresh3, ErrRefreshTokenReused},
+		{"new refresh token still works", refresh2, refresh3, nil},
+		{"other session is untouched", otherRefresh, otherRefresh, nil},
+		{"malformed new refresh token", refresh3, "not-a-token", ErrTokenMalformed},
+	}
+	for _, step := range steps {
+		if err := s.RotateSession(step.old, step.new); !errors.Is(err, step.want) {
+			t.Fatalf("%s: RotateSession() error = %v, want %v", step.name, err, step.want)
+		}
+	}
+
+	// Después del logout la sesión ya no existe: no es una reutilización
+	if err := s.RevokeSession(uid, first.Session); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateSession(refresh3, refresh3); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("after logout: RotateSession() error = %v, want %v", err, ErrSessionEnded)
+	}
+	if revoked, err := s.IsRevoked(first); err != nil || !revoked {
+		t.Errorf("access token of the closed session: IsRevoked() = %t, %v, want true", revoked, err)
+	}
+	if revoked, err := s.IsRevoked(other); err != nil || revoked {
+		t.Errorf("access token of the other session: IsRevoked() = %t, %v, want false", revoked, err)
+	}
+}
+
+func TestRotateLegacySession(t *testing.T) {
+	c := useTestConfig(t)
+	s := testStore(t)
+	uid := newUser(t, s)
+
+	// Los refresh tokens de antes de las sesiones no tienen sid y quedaban guardados en el usuario
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &SignedDetails{
+		Uid: uid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString(c.RefreshSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.userCollection.UpdateOne(context.Background(),
+		bson.D{primitive.E{Key: "user_id", Value: uid}},
+		bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "refresh_token", Value: legacy}}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, refresh := pairFor(t, uid, "")
+	if err := s.RotateSession(legacy, refresh); err != nil {
+		t.Fatalf("RotateSession(legacy) error = %v", err)
+	}
+	if err := s.RotateSession(legacy, refresh); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("legacy refresh token used twice: error = %v, want %v", err, ErrSessionEnded)
+	}
+
+	// El par viejo se borró y el refresh token nuevo es el vigente de una sesión
+	claims, _ := ValidateRefreshToken(refresh)
+	_, next := pairFor(t, uid, claims.Session)
+	if err := s.RotateSession(refresh, next); err != nil {
+		t.Errorf("RotateSession(converted session) error = %v", err)
+	}
+	count, err := s.userCollection.CountDocuments(context.Background(), bson.D{
+		primitive.E{Key: "user_id", Value: uid},
+		primitive.E{Key: "refresh_token", Value: bson.D{primitive.E{Key: "$exists", Value: true}}},
+	})
+	if err != nil || count != 0 {
+		t.Errorf("legacy refresh token still stored: count = %d, error = %v", count, err)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	useTestConfig(t)
+	s := testStore(t)
+	uid := newUser(t, s)
+
+	var accessTokens []*SignedDetails
+	var refreshTokens []string
+	for i := 0; i < 2; i++ {
+		access, refresh := pairFor(t, uid, "")
+		if err := s.StartSession(uid, refresh); err != nil {
+			t.Fatal(err)
+		}
+		accessTokens = append(accessTokens, access)
+		refreshTokens = append(refreshTokens, refresh)
+	}
+
+	if err := s.RevokeAllSessions(uid); err != nil {
+		t.Fatal(err)
+	}
+	// Los tokens se emitieron en el mismo segundo que el logout: los cubren las entradas por sesión
+	for i, access := range accessTokens {
+		if revoked, err := s.IsRevoked(access); err != nil || !revoked {
+			t.Errorf("access token %d: IsRevoked() = %t, %v, want true", i, revoked, err)
+		}
+		if err := s.RotateSession(refreshTokens[i], refreshTokens[i]); !errors.Is(err, ErrSessionEnded) {
+			t.Errorf("refresh token %d: RotateSession() error = %v, want %v", i, err, ErrSessionEnded)
+		}
+	}
+}
@@ -1,9 +1,137 @@
 package tokens
 
-func TokenGenerator(email string, firstname string, lastname string, uid string) (signedtoken string, signeredrefreshtoken string, err error) {
-	panic("Func para generar tokens")
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SignedDetails son los claims que viajan dentro de cada token.
+// Además de los datos del usuario incluimos los claims registrados de JWT (exp, iat, etc).
+// Session identifica la sesión (un login en un dispositivo) a la que pertenece el token;
+// los tokens emitidos antes de que existieran las sesiones no la tienen.
+type SignedDetails struct {
+	Email      string `json:"email"`
+	First_Name string `json:"first_name"`
+	Last_Name  string `json:"last_name"`
+	Uid        string `json:"uid"`
+	User_Type  string `json:"user_type"`
+	Session    string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Store guarda las sesiones abiertas (en la colección de usuarios) y la lista de revocación.
+// Recibe sus colecciones al crearse, igual que controllers.Application, así importar el
+// paquete no abre ninguna conexión.
+type Store struct {
+	userCollection    *mongo.Collection
+	revokedCollection *mongo.Collection
+}
+
+// NewStore crea el Store con la colección de usuarios y la de tokens revocados.
+func NewStore(userCollection, revokedCollection *mongo.Collection) *Store {
+	return &Store{
+		userCollection:    userCollection,
+		revokedCollection: revokedCollection,
+	}
+}
+
+// TokenGenerator emite un par de tokens de la sesión indicada. Con session vacía
+// los tokens abren una sesión nueva (ver Store.StartSession).
+func TokenGenerator(email string, firstname string, lastname string, uid string, usertype string, session string) (signedtoken string, signeredrefreshtoken string, err error) {
+	now := time.Now()
+	if session == "" {
+		session = primitive.NewObjectID().Hex()
+	}
+
+	// El access token lleva todos los datos del usuario y tiene una vida corta
+	claims := &SignedDetails{
+		Email:      email,
+		First_Name: firstname,
+		Last_Name:  lastname,
+		Uid:        uid,
+		User_Type:  usertype,
+		Session:    session,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Subject:   uid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.AccessTTL)),
+		},
+	}
+
+	// El refresh token vive más tiempo y se firma con otra clave,
+	// así no puede usarse como access token.
+	refreshClaims := &SignedDetails{
+		Uid:     uid,
+		Session: session,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Subject:   uid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.RefreshTTL)),
+		},
+	}
+
+	signedtoken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.AccessSecret)
+	if err != nil {
+		return "", "", err
+	}
+
+	signeredrefreshtoken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString(config.RefreshSecret)
+	if err != nil {
+		return "", "", err
+	}
+
+	return signedtoken, signeredrefreshtoken, nil
+}
+
+// Errores que devuelve ValidateToken, para que quien lo llame pueda distinguir el motivo del rechazo
+var (
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenInvalid          = errors.New("token is invalid")
+	ErrRefreshTokenReused    = errors.New("refresh token was already used")
+	ErrSessionEnded          = errors.New("session was logged out")
+)
+
+// ValidateToken verifica la firma y la vigencia de un access token y devuelve sus claims.
+func ValidateToken(signedtoken string) (*SignedDetails, error) {
+	return parseToken(signedtoken, config.AccessSecret)
+}
+
+// ValidateRefreshToken verifica la firma y la vigencia de un refresh token.
+func ValidateRefreshToken(signedrefreshtoken string) (*SignedDetails, error) {
+	return parseToken(signedrefreshtoken, config.RefreshSecret)
 }
 
-func UpdateAllTokens(signedtoken string, signedrefreshtoken string, userid string) {
-	panic("Func para actualizar tokens")
+// parseToken valida un token firmado con la clave indicada y traduce los errores
+// de la librería jwt a los errores propios del paquete.
+func parseToken(signedtoken string, secret []byte) (*SignedDetails, error) {
+	claims := &SignedDetails{}
+	token, err := jwt.ParseWithClaims(signedtoken, claims, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+
+	switch {
+	case err == nil && token.Valid:
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return nil, ErrTokenMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return nil, ErrTokenSignatureInvalid
+	default:
+		return nil, ErrTokenInvalid
+	}
+
+	// Un token sin uid no nos sirve para identificar al usuario
+	if claims.Uid == "" {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
 }
@@ -0,0 +1,171 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// useTestConfig deja una configuración fija mientras dura el test y restaura la anterior al final.
+func useTestConfig(t *testing.T) Config {
+	t.Helper()
+	previous := config
+	t.Cleanup(func() { SetConfig(previous) })
+
+	c := Config{
+		AccessSecret:  []byte("access-secret"),
+		RefreshSecret: []byte("refresh-secret"),
+		AccessTTL:     time.Hour,
+		RefreshTTL:    24 * time.Hour,
+	}
+	SetConfig(c)
+	return c
+}
+
+func generate(t *testing.T, session string) (string, string) {
+	t.Helper()
+	signedtoken, signedrefreshtoken, err := TokenGenerator("ana@example.com", "Ana", "Diaz", primitive.NewObjectID().Hex(), "USER", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedtoken, signedrefreshtoken
+}
+
+// sign firma claims a mano, para armar tokens que TokenGenerator nunca emitiría.
+func sign(t *testing.T, method jwt.SigningMethod, claims *SignedDetails, secret interface{}) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateToken(t *testing.T) {
+	c := useTestConfig(t)
+	access, refresh := generate(t, "")
+
+	now := time.Now()
+	expired := &SignedDetails{Uid: "u1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute))}}
+	valid := &SignedDetails{Uid: "u1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}}
+	withoutUid := &SignedDetails{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))}}
+	withoutExpiry := &SignedDetails{Uid: "u1"}
+
+	tests := []struct {
+		name     string
+		validate func(string) (*SignedDetails, error)
+		token    string
+		want     error
+	}{
+		{"access token", ValidateToken, access, nil},
+		{"refresh token", ValidateRefreshToken, refresh, nil},
+		{"expired access token", ValidateToken, sign(t, jwt.SigningMethodHS256, expired, c.AccessSecret), ErrTokenExpired},
+		{"expired refresh token", ValidateRefreshToken, sign(t, jwt.SigningMethodHS256, expired, c.RefreshSecret), ErrTokenExpired},
+		{"signed with another key", ValidateToken, sign(t, jwt.SigningMethodHS256, valid, []byte("other-secret")), ErrTokenSignatureInvalid},
+		{"refresh token used as access token", ValidateToken, refresh, ErrTokenSignatureInvalid},
+		{"access token used as refresh token", ValidateRefreshToken, access, ErrTokenSignatureInvalid},
+		{"other signing method", ValidateToken, sign(t, jwt.SigningMethodHS512, valid, c.AccessSecret), ErrTokenSignatureInvalid},
+		{"unsigned", ValidateToken, sign(t, jwt.SigningMethodNone, valid, jwt.UnsafeAllowNoneSignatureType), ErrTokenSignatureInvalid},
+		{"malformed", ValidateToken, "not-a-token", ErrTokenMalformed},
+		{"without uid", ValidateToken, sign(t, jwt.SigningMethodHS256, withoutUid, c.AccessSecret), ErrTokenInvalid},
+		{"without expiry", ValidateToken, sign(t, jwt.SigningMethodHS256, withoutExpiry, c.AccessSecret), ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.validate(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && claims.Uid == "" {
+				t.Error("claims without uid")
+			}
+		})
+	}
+}
+
+func TestSecretsAreSeparate(t *testing.T) {
+	c := useTestConfig(t)
+	access, refresh := generate(t, "")
+
+	// Cambiar la clave de los access tokens no toca a los refresh tokens, y al revés
+	c.AccessSecret = []byte("rotated-access-secret")
+	SetConfig(c)
+	if _, err := ValidateToken(access); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("access token after rotating its key: error = %v, want %v", err, ErrTokenSignatureInvalid)
+	}
+	if _, err := ValidateRefreshToken(refresh); err != nil {
+		t.Errorf("refresh token after rotating the access key: error = %v", err)
+	}
+
+	c.RefreshSecret = []byte("rotated-refresh-secret")
+	SetConfig(c)
+	if _, err := ValidateRefreshToken(refresh); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("refresh token after rotating its key: error = %v, want %v", err, ErrTokenSignatureInvalid)
+	}
+}
+
+func TestTokenGenerator(t *testing.T) {
+	useTestConfig(t)
+
+	tests := []struct {
+		name    string
+		session string
+	}{
+		{"new session", ""},
+		{"existing session", "session-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedtoken, signedrefreshtoken := generate(t, tt.session)
+			access, err := ValidateToken(signedtoken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refresh, err := ValidateRefreshToken(signedrefreshtoken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.session != "" && access.Session != tt.session {
+				t.Errorf("session = %q, want %q", access.Session, tt.session)
+			}
+			if access.Session == "" || access.Session != refresh.Session {
+				t.Errorf("access session %q and refresh session %q should be the same and not empty", access.Session, refresh.Session)
+			}
+			if access.ID == refresh.ID {
+				t.Error("access and refresh tokens share the jti")
+			}
+			if access.Email != "ana@example.com" || access.First_Name != "Ana" || access.Last_Name != "Diaz" || access.User_Type != "USER" {
+				t.Errorf("access claims = %+v", access)
+			}
+			// El refresh token solo identifica al usuario y la sesión
+			if refresh.Email != "" || refresh.User_Type != "" || refresh.Uid != access.Uid {
+				t.Errorf("refresh claims = %+v", refresh)
+			}
+			if !refresh.ExpiresAt.After(access.ExpiresAt.Time) {
+				t.Errorf("refresh token expires at %v, before the access token at %v", refresh.ExpiresAt, access.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	useTestConfig(t)
+	access, refresh := generate(t, "")
+
+	session, err := NewSession(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, _ := ValidateRefreshToken(refresh)
+	if session.Session_ID != claims.Session || session.Refresh_ID != claims.ID || !session.Expires_At.Equal(claims.ExpiresAt.Time) {
+		t.Errorf("session = %+v, claims = %+v", session, claims)
+	}
+
+	if _, err := NewSession(access); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("NewSession(access token) error = %v, want %v", err, ErrTokenSignatureInvalid)
+	}
+}